@@ -0,0 +1,272 @@
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Collapse is the inverse of Expand. If path is the home directory or lies
+// beneath it, the home directory prefix is replaced with `~`. Any other path
+// is returned as-is.
+//
+// Both the home directory and path are cleaned before they are compared, and
+// only whole path elements match, so "/home/bobby" is not collapsed when the
+// home directory is "/home/bob". On Windows and macOS the comparison is case
+// insensitive. A home directory that is a filesystem root is never
+// collapsed.
+func Collapse(path string) (string, error) {
+	if path == "" || !filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return collapse(dir, path, caseInsensitiveFS()), nil
+}
+
+// CollapseSlash is like Collapse but always uses forward slashes as
+// separators, so that "C:\Users\bob\projects\x" becomes "~/projects/x" on
+// Windows, for display in contexts such as web views. Paths that are not
+// collapsed are converted as well.
+func CollapseSlash(path string) (string, error) {
+	collapsed, err := Collapse(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(collapsed), nil
+}
+
+// CollapseResolved is like Collapse but also matches when the home directory
+// and path only agree after resolving symbolic links, as when HOME is a
+// symlink to an NFS mount and path uses the physical location, or the other
+// way around. It first tries a plain Collapse and only then resolves both
+// with filepath.EvalSymlinks; a path that cannot be resolved, for example
+// because it doesn't exist, is compared unresolved.
+func CollapseResolved(path string) (string, error) {
+	if path == "" || !filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	fold := caseInsensitiveFS()
+	if collapsed := collapse(dir, path, fold); collapsed != path {
+		return collapsed, nil
+	}
+
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	resolvedPath := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		resolvedPath = resolved
+	}
+
+	if collapsed := collapse(dir, resolvedPath, fold); collapsed != resolvedPath {
+		return collapsed, nil
+	}
+	return path, nil
+}
+
+// CollapseShort is like Collapse but also shortens deep paths for display,
+// such as in breadcrumbs. If the collapsed path has more than maxComponents
+// elements, the middle ones are replaced by a single "…", keeping
+// maxComponents elements in all: the first, which anchors the path, and the
+// last maxComponents-1. With a home directory of /home/bob and a
+// maxComponents of 2, "/home/bob/projects/x" becomes "~/…/x", and with 1 it
+// becomes "~/…". Outside the home directory the root is not an element of
+// its own but part of the anchor, so "/usr/local/share/doc" becomes
+// "/usr/…/doc" rather than a "/…/doc" that reads like a real path. Paths
+// with at most maxComponents elements are only collapsed. An error is
+// returned if maxComponents is less than 1.
+func CollapseShort(path string, maxComponents int) (string, error) {
+	if maxComponents < 1 {
+		return "", fmt.Errorf("invalid maxComponents %d", maxComponents)
+	}
+
+	collapsed, err := Collapse(path)
+	if err != nil || collapsed == "" {
+		return collapsed, err
+	}
+
+	sep := string(filepath.Separator)
+	clean := filepath.Clean(collapsed)
+	root := filepath.VolumeName(clean)
+	rest := clean[len(root):]
+	if rest != "" && os.IsPathSeparator(rest[0]) {
+		root, rest = root+sep, rest[1:]
+	}
+	elems := strings.Split(rest, sep)
+	if len(elems) <= maxComponents {
+		return collapsed, nil
+	}
+
+	short := append([]string{root + elems[0], "…"}, elems[len(elems)-maxComponents+1:]...)
+	return strings.Join(short, sep), nil
+}
+
+// ExpandPair returns both the expanded form of path, as by Expand, and its
+// collapsed form for display, as by Collapse, resolving the home directory
+// only once. "~/x", "/home/bob/x" and "~/y/../x" all yield
+// "/home/bob/x", "~/x" with a home directory of /home/bob. Paths that are
+// relative or outside the home directory are returned as-is in both forms.
+//
+// The expanded form is passed through the function set with
+// SetExpandPostProcessor, like that of Expand, and the display form is the
+// collapsed form of the post-processed path.
+func ExpandPair(path string) (absolute string, display string, err error) {
+	tilde, err := hasTilde(path)
+	if err != nil {
+		return "", "", err
+	} else if !tilde && !filepath.IsAbs(path) {
+		return path, path, nil
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", "", err
+	}
+
+	absolute = path
+	if tilde {
+		absolute = postProcess(joinHome(dir, path[1:]))
+	}
+	return absolute, collapse(dir, absolute, caseInsensitiveFS()), nil
+}
+
+// Collapser collapses paths beneath the home directories of all known users
+// at once, see NewCollapser.
+type Collapser struct {
+	homes []collapserHome
+	fold  bool
+}
+
+type collapserHome struct {
+	dir    string
+	prefix string
+}
+
+// NewCollapser returns a Collapser that knows the home directory of the
+// current user, which collapses to `~`, and those of all users listed by
+// AllUsers, which collapse to `~user`. Everything is looked up once, so
+// collapsing many paths is cheap. On Windows, where AllUsers is not
+// supported, only the current user's home is known.
+func NewCollapser() (*Collapser, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := AllUsers()
+	if err != nil && err != ErrUnsupportedPlatform {
+		return nil, err
+	}
+
+	return newCollapser(dir, users, caseInsensitiveFS()), nil
+}
+
+func newCollapser(dir string, users []UserInfo, fold bool) *Collapser {
+	c := &Collapser{fold: fold}
+	seen := map[string]bool{}
+	add := func(dir, prefix string) {
+		dir = filepath.Clean(dir)
+		isRoot := len(dir) == len(filepath.VolumeName(dir))+1
+		if !filepath.IsAbs(dir) || isRoot || seen[dir] {
+			return
+		}
+		seen[dir] = true
+		c.homes = append(c.homes, collapserHome{dir, prefix})
+	}
+
+	add(dir, "~")
+	for _, u := range users {
+		add(u.Dir, "~"+u.Name)
+	}
+
+	// Longest first, so that a home nested in another one wins.
+	sort.SliceStable(c.homes, func(i, j int) bool {
+		return len(c.homes[i].dir) > len(c.homes[j].dir)
+	})
+	return c
+}
+
+// Collapse is like the package-level Collapse but also replaces the home
+// directory of other users with `~user`. If homes are nested, the longest
+// matching one is used.
+func (c *Collapser) Collapse(path string) string {
+	if path == "" || !filepath.IsAbs(path) {
+		return path
+	}
+
+	cleaned := filepath.Clean(path)
+	for _, h := range c.homes {
+		if len(cleaned) < len(h.dir) {
+			continue
+		}
+		prefix, rest := cleaned[:len(h.dir)], cleaned[len(h.dir):]
+		if rest != "" && rest[0] != filepath.Separator {
+			continue
+		}
+		if prefix == h.dir || (c.fold && strings.EqualFold(prefix, h.dir)) {
+			return h.prefix + rest
+		}
+	}
+	return path
+}
+
+// Unexpand is a synonym for Collapse.
+func Unexpand(path string) (string, error) {
+	return Collapse(path)
+}
+
+// caseInsensitiveFS reports whether paths on this platform are compared
+// without regard to case.
+func caseInsensitiveFS() bool {
+	return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
+
+// collapse replaces the home prefix of path with `~`. fold selects a case
+// insensitive comparison.
+func collapse(home, path string, fold bool) string {
+	if home == "" || !filepath.IsAbs(path) {
+		return path
+	}
+
+	home = filepath.Clean(home)
+	cleaned := filepath.Clean(path)
+	if len(home) == len(filepath.VolumeName(home))+1 && home[len(home)-1] == filepath.Separator {
+		// Collapsing against a root would turn every path into ~.
+		return path
+	}
+
+	if len(cleaned) < len(home) {
+		return path
+	}
+
+	prefix := cleaned[:len(home)]
+	if prefix != home && !(fold && strings.EqualFold(prefix, home)) {
+		return path
+	}
+
+	rest := cleaned[len(home):]
+	if rest == "" {
+		return "~"
+	}
+	if rest[0] != filepath.Separator {
+		// A sibling sharing the prefix, e.g. /home/bobby for /home/bob.
+		return path
+	}
+
+	return "~" + rest
+}
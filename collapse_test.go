@@ -0,0 +1,312 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// nativePath converts a slash-separated absolute test path into an absolute
+// path for this platform.
+func nativePath(s string) string {
+	if runtime.GOOS == "windows" && strings.HasPrefix(s, "/") {
+		s = "C:" + s
+	}
+	return filepath.FromSlash(s)
+}
+
+func TestCollapse(t *testing.T) {
+	p := nativePath
+	home := p("/home/bob")
+
+	cases := []struct {
+		Home   string
+		Input  string
+		Fold   bool
+		Output string
+	}{
+		{home, "", false, ""},
+		{home, p("foo/bar"), false, p("foo/bar")},
+		{home, p("/home/bob"), false, "~"},
+		{home, p("/home/bob/"), false, "~"},
+		{home, p("/home/bob/foo"), false, p("~/foo")},
+		{home, p("/home/bob//foo/./bar"), false, p("~/foo/bar")},
+		{p("/home/bob/"), p("/home/bob/foo"), false, p("~/foo")},
+		{home, p("/home/bobby/foo"), false, p("/home/bobby/foo")},
+		{home, p("/home/bob/../alice"), false, p("/home/bob/../alice")},
+		{home, p("/home"), false, p("/home")},
+		{home, p("/etc/passwd"), false, p("/etc/passwd")},
+		{p("/home/bob/sub"), p("/home/bob/sub/x"), false, p("~/x")},
+		{home, p("/HOME/Bob/foo"), false, p("/HOME/Bob/foo")},
+		{home, p("/HOME/Bob/foo"), true, p("~/foo")},
+		{p("/"), p("/etc"), false, p("/etc")},
+		{"", p("/home/bob"), false, p("/home/bob")},
+	}
+
+	for _, tc := range cases {
+		actual := collapse(tc.Home, tc.Input, tc.Fold)
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc, actual)
+		}
+	}
+}
+
+func TestCollapseShort(t *testing.T) {
+	p := nativePath
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(p("/home/bob"))()
+
+	cases := []struct {
+		Input  string
+		Max    int
+		Output string
+		Err    bool
+	}{
+		{p("/home/bob/a/b/c"), 0, "", true},
+		{p("/home/bob/a/b/c"), 1, p("~/…"), false},
+		{p("/home/bob/a/b/c"), 2, p("~/…/c"), false},
+		{p("/home/bob/projects/x"), 3, p("~/projects/x"), false},
+		{p("/home/bob/a/b/c/d"), 3, p("~/…/c/d"), false},
+		{p("/home/bob/x"), 2, p("~/x"), false},
+		{p("/home/bob"), 1, "~", false},
+		{p("/usr/local/share/doc"), 0, "", true},
+		{p("/usr/local/share/doc"), 1, p("/usr/…"), false},
+		{p("/usr/local/share/doc"), 2, p("/usr/…/doc"), false},
+		{p("/usr/local/share/doc"), 3, p("/usr/…/share/doc"), false},
+		{p("/usr/local"), 2, p("/usr/local"), false},
+		{p("/"), 1, p("/"), false},
+		{"a/b/c", 2, filepath.FromSlash("a/…/c"), false},
+		{"a/b", 2, "a/b", false},
+		{"", 2, "", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := CollapseShort(tc.Input, tc.Max)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v, %d\n\nErr: %v", tc.Input, tc.Max, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v, %d\n\nOutput: %#v", tc.Input, tc.Max, actual)
+		}
+	}
+}
+
+func TestCollapseSlash(t *testing.T) {
+	p := nativePath
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(p("/home/bob"))()
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{p("/home/bob/projects/x"), "~/projects/x"},
+		{p("/home/bob"), "~"},
+		{p("/srv/x"), filepath.ToSlash(p("/srv/x"))},
+		{"rel/x", "rel/x"},
+	}
+
+	for _, tc := range cases {
+		actual, err := CollapseSlash(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestUnexpand(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", nativePath("/home/bob"))()
+	defer patchEnv("USERPROFILE", nativePath("/home/bob"))()
+
+	input := filepath.Join(nativePath("/home/bob"), "foo")
+	collapsed, err := Collapse(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	unexpanded, err := Unexpand(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if collapsed != unexpanded || collapsed != filepath.Join("~", "foo") {
+		t.Fatalf("Collapse %#v, Unexpand %#v", collapsed, unexpanded)
+	}
+}
+
+func TestExpandPair(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	p := nativePath
+	home := p("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input    string
+		Absolute string
+		Display  string
+	}{
+		{"~", home, "~"},
+		{"~/x", p("/home/bob/x"), p("~/x")},
+		{"~/y/../x", p("/home/bob/x"), p("~/x")},
+		{p("/home/bob/x"), p("/home/bob/x"), p("~/x")},
+		{p("/etc/passwd"), p("/etc/passwd"), p("/etc/passwd")},
+		{"foo/bar", "foo/bar", "foo/bar"},
+		{"", "", ""},
+	}
+
+	for _, tc := range cases {
+		absolute, display, err := ExpandPair(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if absolute != tc.Absolute || display != tc.Display {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v", tc.Input, absolute, display)
+		}
+	}
+
+	if _, _, err := ExpandPair("~alice/x"); err == nil {
+		t.Fatalf("expected error for ~alice/x")
+	}
+
+	// The expanded form matches Expand once a post-processor is set
+	defer SetExpandPostProcessor(nil)
+	SetExpandPostProcessor(func(path string) string {
+		return strings.Replace(path, home, p("/exported/home/bob"), 1)
+	})
+	absolute, display, err := ExpandPair("~/x")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expanded, _ := Expand("~/x"); absolute != expanded || absolute != p("/exported/home/bob/x") {
+		t.Fatalf("expected %v got %v", expanded, absolute)
+	}
+	if display != absolute {
+		t.Fatalf("expected display %v got %v", absolute, display)
+	}
+}
+
+func TestCollapseResolved(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	physical := filepath.Join(tmp, "export", "bob")
+	link := filepath.Join(tmp, "bob")
+	if err := os.MkdirAll(filepath.Join(physical, "x"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Symlink(physical, link); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	cases := []struct {
+		Home   string
+		Input  string
+		Output string
+	}{
+		{link, filepath.Join(physical, "x"), filepath.Join("~", "x")},
+		{physical, filepath.Join(link, "x"), filepath.Join("~", "x")},
+		{link, filepath.Join(link, "missing"), filepath.Join("~", "missing")},
+		{link, filepath.Join(tmp, "export"), filepath.Join(tmp, "export")},
+	}
+
+	for _, tc := range cases {
+		restore := patchHome(tc.Home)
+		actual, err := CollapseResolved(tc.Input)
+		restore()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v, %#v\n\nOutput: %#v", tc.Home, tc.Input, actual)
+		}
+	}
+
+	// Collapse itself keeps comparing the paths as given.
+	defer patchHome(link)()
+	input := filepath.Join(physical, "x")
+	if actual, _ := Collapse(input); actual != input {
+		t.Fatalf("Collapse resolved symlinks: %#v", actual)
+	}
+}
+
+func TestCollapser(t *testing.T) {
+	p := nativePath
+	users := []UserInfo{
+		{"root", 0, p("/root")},
+		{"bob", 1000, p("/home/bob")},
+		{"alice", 1001, p("/home/alice")},
+		{"svc", 998, p("/home/alice/services/svc")},
+		{"nobody", 65534, p("/")},
+		{"broken", 1002, "relative"},
+	}
+	c := newCollapser(p("/home/bob"), users, false)
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{p("/home/bob/x"), p("~/x")},
+		{p("/home/bob"), "~"},
+		{p("/home/alice/x"), p("~alice/x")},
+		{p("/home/alice/services/svc/log"), p("~svc/log")},
+		{p("/home/alice/services"), p("~alice/services")},
+		{p("/root/.profile"), p("~root/.profile")},
+		{p("/home/bobby/x"), p("/home/bobby/x")},
+		{p("/etc/passwd"), p("/etc/passwd")},
+		{"relative/x", "relative/x"},
+		{"", ""},
+	}
+
+	for _, tc := range cases {
+		actual := c.Collapse(tc.Input)
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func benchmarkPaths() []string {
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = filepath.Join(nativePath("/home/bob/projects"), strconv.Itoa(i), "main.go")
+	}
+	return paths
+}
+
+func BenchmarkCollapse(b *testing.B) {
+	defer patchHome(nativePath("/home/bob"))()
+	Reset()
+	defer Reset()
+	paths := benchmarkPaths()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Collapse(paths[i%len(paths)])
+	}
+}
+
+func BenchmarkCollapser(b *testing.B) {
+	defer patchHome(nativePath("/home/bob"))()
+	Reset()
+	defer Reset()
+	c, err := NewCollapser()
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	paths := benchmarkPaths()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Collapse(paths[i%len(paths)])
+	}
+}
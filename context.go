@@ -0,0 +1,65 @@
+package homedir
+
+import (
+	"context"
+	"sync"
+)
+
+type memoKey struct{}
+
+// memo holds the values resolved within one context, see WithCache.
+type memo struct {
+	mu   sync.Mutex
+	dir  string
+	user string
+}
+
+// WithCache returns a copy of ctx carrying a memo of its own, separate from
+// the package cache, so that DirContext and UserContext called with it, or
+// with a context derived from it, each resolve their value at most once.
+// This gives values that are fresh for every operation yet cached within
+// one, even when DisableCache is set. Only successful results are
+// remembered.
+func WithCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, memoKey{}, &memo{})
+}
+
+// DirContext is like Dir but uses the memo of a context created by
+// WithCache, if any. Without one it is just Dir().
+func DirContext(ctx context.Context) (string, error) {
+	m, ok := ctx.Value(memoKey{}).(*memo)
+	if !ok {
+		return Dir()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir == "" {
+		dir, err := Dir()
+		if err != nil {
+			return "", err
+		}
+		m.dir = dir
+	}
+	return m.dir, nil
+}
+
+// UserContext is like User but uses the memo of a context created by
+// WithCache, if any. Without one it is just User().
+func UserContext(ctx context.Context) (string, error) {
+	m, ok := ctx.Value(memoKey{}).(*memo)
+	if !ok {
+		return User()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.user == "" {
+		user, err := User()
+		if err != nil {
+			return "", err
+		}
+		m.user = user
+	}
+	return m.user, nil
+}
@@ -0,0 +1,61 @@
+package homedir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestWithCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix discovery methods")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", "")()
+	defer patchEnv("USER", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer SetUserMethodOrder(nil)
+	if err := SetUserMethodOrder([]string{"getent"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	calls := 0
+	uid := strconv.Itoa(os.Getuid())
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		calls++
+		if name != "getent" {
+			return nil, errors.New("not found")
+		}
+		return []byte("bob:x:" + uid + ":1000::/home/bob:/bin/sh\n"), nil
+	})()
+
+	ctx := WithCache(context.Background())
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if dir, err := DirContext(child); err != nil || dir != "/home/bob" {
+			t.Fatalf("DirContext: %#v, %v", dir, err)
+		}
+		if user, err := UserContext(ctx); err != nil || user != "bob" {
+			t.Fatalf("UserContext: %#v, %v", user, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 commands within the operation, got %d", calls)
+	}
+
+	// Another operation resolves afresh, and without a memo nothing is
+	// remembered while DisableCache is set.
+	calls = 0
+	DirContext(WithCache(context.Background()))
+	DirContext(context.Background())
+	DirContext(context.Background())
+	if calls != 3 {
+		t.Fatalf("expected 3 commands, got %d", calls)
+	}
+}
@@ -0,0 +1,98 @@
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Diagnose returns a human-readable, multi-line report of how the home
+// directory and user name are discovered on this system. It lists the
+// relevant environment variables, the output of every discovery command and
+// the values Dir() and User() would return without the cache, determined in
+// the same order as Dir() and User() themselves.
+//
+// Failures of individual methods are noted in the report rather than
+// returned. Diagnose does not read or modify the cache. The commands are run
+// without the package lock, so slow ones don't hold up other callers; only
+// the final discovery holds it, like a Dir() with a cold cache.
+func Diagnose() string {
+	cacheLock.RLock()
+	uid := strconv.Itoa(lookupUID())
+	runner, shEnv := run, shellEnv()
+	cacheLock.RUnlock()
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "homedir diagnostics (%s/%s)\n", runtime.GOOS, runtime.GOARCH)
+
+	envVars := []string{"HOME", "USER", "XDG_RUNTIME_DIR", "SUDO_USER", "DOAS_USER", "PKEXEC_UID"}
+	if runtime.GOOS == "windows" {
+		envVars = []string{"HOME", "USERPROFILE", "HOMEDRIVE", "HOMESHARE", "HOMEPATH", "USERNAME"}
+	}
+	if HomesEnv != "" {
+		envVars = append(envVars, HomesEnv)
+	}
+
+	b.WriteString("environment:\n")
+	for _, key := range envVars {
+		if value, ok := os.LookupEnv(key); ok {
+			fmt.Fprintf(&b, "  %s=%q\n", key, value)
+		} else {
+			fmt.Fprintf(&b, "  %s is not set\n", key)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		b.WriteString("commands:\n")
+		commands := [][]string{
+			{"systemctl", "--user", "show-environment"},
+			{"getent", "passwd", uid},
+			{"whoami"},
+			{"id"},
+		}
+		if runtime.GOOS == "freebsd" || runtime.GOOS == "dragonfly" {
+			commands = append(commands, []string{"pw", "usershow", "-P", "-u", uid})
+		}
+		commands = append(commands, []string{"sh", "-c", "cd && pwd"})
+		for _, c := range commands {
+			var env []string
+			switch c[0] {
+			case "id":
+				env = cLocaleEnv()
+			case "sh":
+				env = shEnv
+			}
+			out, err := runner(env, c[0], c[1:]...)
+			result := strings.TrimSpace(string(out))
+			if err != nil {
+				result = "error: " + err.Error()
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", strings.Join(c, " "), result)
+		}
+	}
+
+	cacheLock.RLock()
+	dir, source, pinned, dirErr := pinnedDir()
+	if !pinned {
+		dir, source, dirErr = discoverDir()
+	}
+	user, userSrc, userErr := discoverUser()
+	cacheLock.RUnlock()
+
+	b.WriteString("result:\n")
+	if dirErr != nil {
+		fmt.Fprintf(&b, "  Dir(): error: %v\n", dirErr)
+	} else {
+		fmt.Fprintf(&b, "  Dir(): %s (%s)\n", dir, source)
+	}
+	if userErr != nil {
+		fmt.Fprintf(&b, "  User(): error: %v\n", userErr)
+	} else {
+		fmt.Fprintf(&b, "  User(): %s (%s)\n", user, userSrc)
+	}
+
+	return b.String()
+}
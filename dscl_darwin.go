@@ -0,0 +1,32 @@
+//go:build darwin
+
+package homedir
+
+import "path/filepath"
+
+// checkDsclHome compares home, taken from the environment, with the
+// NFSHomeDirectory of the current user in Directory Services and logs a
+// mismatch, which usually means a stale HOME inherited from a parent process,
+// such as an app launched from Finder. It only runs dscl when a logger is
+// set with SetLogger, and never changes the result of Dir(). The caller must
+// hold cacheLock.
+func checkDsclHome(home string) {
+	if logger == nil {
+		return
+	}
+
+	user, _, err := discoverUser()
+	if err != nil {
+		logger("cannot check HOME against dscl", "err", err)
+		return
+	}
+	out, err := run(nil, "dscl", ".", "-read", "/Users/"+user, "NFSHomeDirectory")
+	if err != nil {
+		logger("cannot check HOME against dscl", "user", user, "err", err)
+		return
+	}
+
+	if nfsHome := parseDsclHome(outputString(out)); nfsHome != "" && filepath.Clean(nfsHome) != home {
+		logger("HOME does not match dscl NFSHomeDirectory", "home", home, "dscl", nfsHome, "user", user)
+	}
+}
@@ -0,0 +1,52 @@
+package homedir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDirDsclMismatch(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	defer patchEnv("HOME", "/Users/stale")()
+	defer patchEnv("USER", "bob")()
+
+	nfsHome := "/Users/stale"
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "dscl" && strings.Join(arg, " ") == ". -read /Users/bob NFSHomeDirectory" {
+			return []byte("NFSHomeDirectory: " + nfsHome + "\n"), nil
+		}
+		return nil, errors.New("unexpected command")
+	})()
+
+	var logged []string
+	SetLogger(func(msg string, keyvals ...interface{}) {
+		logged = append(logged, fmt.Sprint(append([]interface{}{msg}, keyvals...)...))
+	})
+
+	mismatch := func() bool {
+		for _, line := range logged {
+			if strings.HasPrefix(line, "HOME does not match dscl") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, home := range []string{"/Users/stale", "/Users/bob"} {
+		logged = nil
+		nfsHome = home
+		dir, err := Dir()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if dir != "/Users/stale" {
+			t.Fatalf("expected HOME to be kept, got %v", dir)
+		}
+		if expected := home != dir; mismatch() != expected {
+			t.Fatalf("dscl %v: expected mismatch %v, logged %q", home, expected, logged)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !darwin
+
+package homedir
+
+// checkDsclHome is only implemented on macOS, where Directory Services and
+// dscl(1) exist.
+func checkDsclHome(home string) {}
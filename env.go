@@ -0,0 +1,194 @@
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// ExpandHomeVar replaces references to the HOME environment variable in
+// path, written as $HOME or ${HOME}, with its value. On Windows %HOME% and
+// %USERPROFILE% are replaced as well. Other variables are left untouched.
+//
+// Only the environment is consulted: no command is ever run, and an error is
+// returned if a referenced variable is unset or empty. ExpandHomeVar does not
+// resolve `~`; use Expand for that.
+func ExpandHomeVar(path string) (string, error) {
+	return expandHomeVar(path, runtime.GOOS == "windows")
+}
+
+func expandHomeVar(path string, windows bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(path); {
+		name, n := "", 0
+		switch rest := path[i:]; {
+		case strings.HasPrefix(rest, "${HOME}"):
+			name, n = "HOME", len("${HOME}")
+		case strings.HasPrefix(rest, "$HOME") && !isVarChar(rest, len("$HOME")):
+			name, n = "HOME", len("$HOME")
+		case windows && hasPrefixFold(rest, "%HOME%"):
+			name, n = "HOME", len("%HOME%")
+		case windows && hasPrefixFold(rest, "%USERPROFILE%"):
+			name, n = "USERPROFILE", len("%USERPROFILE%")
+		}
+
+		if n == 0 {
+			b.WriteByte(path[i])
+			i++
+			continue
+		}
+
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("%s is referenced in %q but not set", name, path)
+		}
+		b.WriteString(value)
+		i += n
+	}
+
+	return b.String(), nil
+}
+
+// ExpandExceptTilde replaces environment variable references in path,
+// written as $VAR or ${VAR}, with their values but deliberately leaves a
+// leading `~` alone, so that "~/$APP/config" becomes "~/myapp/config". This
+// normalizes a path without binding it to the home directory of the current
+// machine; Expand can resolve the `~` later, for example on another machine.
+//
+// An error is returned if a referenced variable is unset, rather than
+// silently substituting an empty string.
+func ExpandExceptTilde(path string) (string, error) {
+	var missing []string
+	result := os.Expand(path, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s referenced in %q but not set", strings.Join(missing, ", "), path)
+	}
+
+	return result, nil
+}
+
+// crossPlatformVars maps Windows environment variables to the Unix
+// variables used in their place by cross-platform environment expansion,
+// see SetCrossPlatformEnvExpansion.
+var crossPlatformVars = map[string]string{
+	"USERPROFILE": "HOME",
+	"USERNAME":    "USER",
+	"TEMP":        "TMPDIR",
+	"TMP":         "TMPDIR",
+}
+
+// ExpandEnv replaces environment variable references in path, like
+// ExpandExceptTilde, and then expands a leading `~` with Expand. On Windows
+// references written as %VAR% are replaced as well; see
+// SetCrossPlatformEnvExpansion to recognize them on all platforms. Unlike
+// $VAR references, a %VAR% reference to an unset variable is left as-is, as
+// cmd.exe does.
+func ExpandEnv(path string) (string, error) {
+	cacheLock.RLock()
+	cross := crossPlatformEnv
+	cacheLock.RUnlock()
+
+	result, err := ExpandExceptTilde(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" || cross {
+		result = expandPercentVars(result, runtime.GOOS == "windows")
+	}
+
+	return Expand(result)
+}
+
+// ExpandKeepingOriginal expands path like ExpandEnv and also returns path
+// itself. It formalizes keeping both forms of a configured path: expanded is
+// for use at run time, while original, with its `~` and $VAR references
+// intact, is what should be written back when the configuration is saved,
+// so that machine-specific paths don't end up in it. See the example.
+func ExpandKeepingOriginal(path string) (expanded, original string, err error) {
+	expanded, err = ExpandEnv(path)
+	if err != nil {
+		return "", path, err
+	}
+	return expanded, path, nil
+}
+
+// SetCrossPlatformEnvExpansion makes ExpandEnv recognize %VAR% references
+// on all platforms, so that configuration files written on Windows, such as
+// "%USERPROFILE%\.config\app", also work elsewhere. Outside Windows an unset
+// USERPROFILE, USERNAME, TEMP or TMP is replaced by HOME, USER or TMPDIR
+// respectively, and backslashes in a path with a %VAR% reference are turned
+// into slashes. It is disabled by default.
+func SetCrossPlatformEnvExpansion(enabled bool) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	crossPlatformEnv = enabled
+}
+
+// expandPercentVars replaces %VAR% references in path with the values of
+// the variables. Unless windows is set, unset variables are looked up in
+// crossPlatformVars and backslashes are turned into slashes if any
+// reference was replaced.
+func expandPercentVars(path string, windows bool) string {
+	var b strings.Builder
+	replaced := false
+	for i := 0; i < len(path); {
+		end := -1
+		if path[i] == '%' {
+			j := i + 1
+			for isVarChar(path, j) {
+				j++
+			}
+			if j > i+1 && j < len(path) && path[j] == '%' {
+				end = j
+			}
+		}
+		if end < 0 {
+			b.WriteByte(path[i])
+			i++
+			continue
+		}
+
+		name := path[i+1 : end]
+		value, ok := os.LookupEnv(name)
+		if !ok && !windows {
+			if unix, mapped := crossPlatformVars[strings.ToUpper(name)]; mapped {
+				value, ok = os.LookupEnv(unix)
+			}
+		}
+		if !ok {
+			// Leave the reference alone but let the closing % start the
+			// next one, as cmd.exe does
+			b.WriteString(path[i:end])
+			i = end
+			continue
+		}
+		b.WriteString(value)
+		replaced = true
+		i = end + 1
+	}
+
+	if replaced && !windows {
+		return strings.ReplaceAll(b.String(), "\\", "/")
+	}
+	return b.String()
+}
+
+// isVarChar reports whether s[i] exists and may continue a variable name.
+func isVarChar(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,155 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandHomeVar(t *testing.T) {
+	defer patchEnv("HOME", "/home/bob")()
+	defer patchEnv("USERPROFILE", `C:\Users\bob`)()
+
+	cases := []struct {
+		Input   string
+		Windows bool
+		Output  string
+		Err     bool
+	}{
+		{"", false, "", false},
+		{"/etc/x", false, "/etc/x", false},
+		{"$HOME/x", false, "/home/bob/x", false},
+		{"${HOME}/x", false, "/home/bob/x", false},
+		{"${HOME}x", false, "/home/bobx", false},
+		{"$HOMEDIR/x", false, "$HOMEDIR/x", false},
+		{"$USER/$HOME", false, "$USER//home/bob", false},
+		{"~/x", false, "~/x", false},
+		{"%USERPROFILE%/x", false, "%USERPROFILE%/x", false},
+		{`%USERPROFILE%\x`, true, `C:\Users\bob\x`, false},
+		{`%home%\x`, true, `/home/bob\x`, false},
+	}
+
+	for _, tc := range cases {
+		actual, err := expandHomeVar(tc.Input, tc.Windows)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	patchEnv("HOME", "")
+	if _, err := expandHomeVar("$HOME/x", false); err == nil {
+		t.Fatalf("expected error for unset HOME")
+	}
+	if actual, err := expandHomeVar("/x", false); err != nil || actual != "/x" {
+		t.Fatalf("unreferenced HOME: %v, %v", actual, err)
+	}
+}
+
+func TestExpandExceptTilde(t *testing.T) {
+	defer patchEnv("HOME", "/home/bob")()
+	defer patchEnv("HOMEDIR_TEST_APP", "myapp")()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"", "", false},
+		{"~", "~", false},
+		{"~/x", "~/x", false},
+		{"~/$HOMEDIR_TEST_APP/config", "~/myapp/config", false},
+		{"~/${HOMEDIR_TEST_APP}.d", "~/myapp.d", false},
+		{"$HOME/x", "/home/bob/x", false},
+		{"/opt/$HOMEDIR_TEST_APP/~", "/opt/myapp/~", false},
+		{"~/$HOMEDIR_TEST_UNSET/x", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandExceptTilde(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandKeepingOriginal(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	defer patchEnv("HOMEDIR_TEST_APP", "myapp")()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"~/$HOMEDIR_TEST_APP/config", filepath.Join(home, "myapp", "config"), false},
+		{"/opt/x", "/opt/x", false},
+		{"~/$HOMEDIR_TEST_NEVER_SET/x", "", true},
+	}
+
+	for _, tc := range cases {
+		expanded, original, err := ExpandKeepingOriginal(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if expanded != tc.Output || original != tc.Input {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v", tc.Input, expanded, original)
+		}
+	}
+}
+
+func TestExpandEnvCrossPlatform(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("%VAR% references are always expanded on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = true
+	defer patchHome("/home/bob")()
+	defer patchEnv("USERPROFILE", "")()
+	defer patchEnv("USER", "bob")()
+	defer patchEnv("HOMEDIR_TEST_APP", "app")()
+	os.Unsetenv("USERPROFILE")
+
+	cases := []struct {
+		Input  string
+		Cross  bool
+		Output string
+	}{
+		{`%USERPROFILE%\.config\app`, true, "/home/bob/.config/app"},
+		{`%UserProfile%\x`, true, "/home/bob/x"},
+		{"%HOMEDIR_TEST_APP%/x", true, "app/x"},
+		{"/srv/%USERNAME%/data", true, "/srv/bob/data"},
+		{"~/$HOMEDIR_TEST_APP/%HOMEDIR_TEST_APP%", true, "/home/bob/app/app"},
+		{"/tmp/100%/%HOMEDIR_TEST_UNKNOWN%", true, "/tmp/100%/%HOMEDIR_TEST_UNKNOWN%"},
+		{`/tmp/a\b`, true, `/tmp/a\b`},
+		{`%USERPROFILE%\x`, false, `%USERPROFILE%\x`},
+		{"$HOME/x", false, "/home/bob/x"},
+		{"~/x", false, "/home/bob/x"},
+	}
+
+	for _, tc := range cases {
+		SetCrossPlatformEnvExpansion(tc.Cross)
+		actual, err := ExpandEnv(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	if _, err := ExpandEnv("$HOMEDIR_TEST_NEVER_SET/x"); err == nil {
+		t.Fatalf("expected error for an unset variable")
+	}
+}
@@ -0,0 +1,66 @@
+package homedir_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	homedir "github.com/marcopeereboom/go-homedir"
+)
+
+func ExamplePathValue() {
+	var config string
+	fs := flag.NewFlagSet("example", flag.ContinueOnError)
+	fs.Var((*homedir.PathValue)(&config), "config", "config file")
+
+	if err := fs.Parse([]string{"-config", "~/.example.conf"}); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// config now holds the path with ~ replaced by the home directory.
+	fmt.Println(config)
+}
+
+func ExampleExpander() {
+	// Resolve the home directory once, then expand many paths against it.
+	e := homedir.Expander{Home: "/home/bob"}
+	for _, path := range []string{"~/.config/app", "~/logs", "/var/tmp"} {
+		expanded, err := e.Expand(path)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(filepath.ToSlash(expanded))
+	}
+	// Output:
+	// /home/bob/.config/app
+	// /home/bob/logs
+	// /var/tmp
+}
+
+func ExampleExpandKeepingOriginal() {
+	os.Setenv(homedir.OverrideEnv, "/home/bob")
+	defer os.Unsetenv(homedir.OverrideEnv)
+
+	type config struct {
+		LogDir string
+	}
+	saved := config{LogDir: "~/logs"}
+
+	// Use the expanded path at run time, but keep the original around.
+	logDir, original, err := homedir.ExpandKeepingOriginal(saved.LogDir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("using", filepath.ToSlash(logDir))
+
+	// Saving the configuration writes back the portable form.
+	saved.LogDir = original
+	fmt.Println("saving", saved.LogDir)
+	// Output:
+	// using /home/bob/logs
+	// saving ~/logs
+}
@@ -0,0 +1,58 @@
+package homedir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindUpToHome looks for a file or directory called name in start and its
+// parent directories, as git does for .git, and returns the path of the
+// first one found. The search ends after the home directory, which is
+// searched as well, or at the filesystem root if start isn't below the home
+// directory. An empty start is the working directory. Symlinks in start and
+// the home directory are resolved before comparing them, so the returned
+// path is made of resolved directories. An error wrapping ErrNotFound is
+// returned if there is no match.
+func FindUpToHome(name string, start string) (string, error) {
+	if name == "" {
+		return "", errors.New("cannot search for an empty name")
+	}
+
+	home, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	if home, err = canonicalPath(home); err != nil {
+		return "", err
+	}
+	if start == "" {
+		if start, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	dir, err := canonicalPath(start)
+	if err != nil {
+		return "", err
+	}
+
+	fold := caseInsensitiveFS()
+	for {
+		path := filepath.Join(dir, name)
+		if _, err := os.Lstat(path); err == nil {
+			return path, nil
+		}
+		if dir == home || fold && strings.EqualFold(dir, home) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return "", fmt.Errorf("%w: no %s in %s or above, up to %s", ErrNotFound, name, start, dir)
+}
@@ -0,0 +1,77 @@
+package homedir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindUpToHome(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	home := filepath.Join(root, "home", "bob")
+	defer patchHome(home)()
+
+	start := filepath.Join(home, "src", "project", "pkg")
+	for _, dir := range []string{
+		start,
+		filepath.Join(home, "src", "project", ".git"),
+		filepath.Join(root, "outside"),
+	} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	for _, file := range []string{
+		filepath.Join(home, ".toolrc"),
+		filepath.Join(root, "home", ".aboverc"),
+		filepath.Join(root, "outside", ".toolrc"),
+	} {
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	cases := []struct {
+		Name   string
+		Start  string
+		Output string
+		Err    bool
+	}{
+		{".git", start, filepath.Join(home, "src", "project", ".git"), false},
+		{"pkg", start, start, false},
+		{".toolrc", start, filepath.Join(home, ".toolrc"), false},
+		{".toolrc", home, filepath.Join(home, ".toolrc"), false},
+		{".aboverc", start, "", true},
+		{".toolrc", filepath.Join(root, "outside"), filepath.Join(root, "outside", ".toolrc"), false},
+		{".aboverc", filepath.Join(root, "outside"), "", true},
+		{"", start, "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := FindUpToHome(tc.Name, tc.Start)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc, err)
+		}
+		if !tc.Err && actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc, actual)
+		}
+	}
+
+	if _, err := FindUpToHome(".aboverc", start); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	// The working directory is the default start
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(start)
+	if actual, err := FindUpToHome(".git", ""); err != nil || actual != filepath.Join(home, "src", "project", ".git") {
+		t.Fatalf("expected .git from the working directory, got %v: %v", actual, err)
+	}
+}
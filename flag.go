@@ -0,0 +1,46 @@
+package homedir
+
+import (
+	"flag"
+)
+
+// PathValue is a flag.Value for path flags. The value given on the command
+// line is passed through Expand when it is set, so a leading `~` is resolved
+// to the home directory at parse time. An expansion error is reported by the
+// flag package like any other invalid flag value.
+//
+// A *string can be used as a *PathValue with a conversion:
+//
+//	var config string
+//	fs.Var((*homedir.PathValue)(&config), "config", "config file")
+type PathValue string
+
+// String returns the (expanded) path.
+func (p *PathValue) String() string {
+	return string(*p)
+}
+
+// Set expands s and stores the result.
+func (p *PathValue) Set(s string) error {
+	expanded, err := Expand(s)
+	if err != nil {
+		return err
+	}
+
+	*p = PathValue(expanded)
+	return nil
+}
+
+// PathVar defines a path flag on flag.CommandLine with the specified name,
+// default value and usage string. The argument p points to a string in
+// which to store the expanded value of the flag.
+//
+// The default value is expanded as well, but is shown unexpanded in the
+// usage message. If the default cannot be expanded it is stored as-is.
+func PathVar(p *string, name, value, usage string) {
+	*p = value
+	flag.Var((*PathValue)(p), name, usage)
+	if expanded, err := Expand(value); err == nil {
+		*p = expanded
+	}
+}
@@ -0,0 +1,137 @@
+package homedir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirFS returns an fs.FS rooted at the home directory, as returned by
+// os.DirFS(Dir()). Use ExpandFS to turn tilde paths into names valid for
+// it.
+func DirFS() (fs.FS, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.DirFS(dir), nil
+}
+
+// ExpandFS converts path into a name relative to the home directory that is
+// valid for the fs.FS returned by DirFS, such as "~/foo/bar" to "foo/bar".
+// The home directory itself becomes ".". Absolute paths beneath the home
+// directory are accepted as well.
+//
+// The result always uses forward slashes and has no volume name, as
+// required by fs.ValidPath. An error is returned for relative paths without
+// a `~` prefix and for paths that are outside the home directory, such as
+// "~/../x".
+func ExpandFS(path string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return expandFS(dir, path)
+}
+
+// ExpandExisting is like Expand but also checks that the path exists in
+// fsys, which is taken to be rooted at the home directory like the fs.FS
+// returned by DirFS. path is converted to a name for fsys as by ExpandFS, so
+// the same restrictions apply. The expanded path is returned; if it doesn't
+// exist in fsys the error wraps fs.ErrNotExist.
+func ExpandExisting(fsys fs.FS, path string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	name, err := expandFS(dir, path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := fs.Stat(fsys, name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%q not found in home directory: %w", path, fs.ErrNotExist)
+		}
+		return "", err
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// ExpandArchivePath converts path into an entry name for a zip or tar
+// archive relative to the home directory, such as "~/docs/x" to "docs/x".
+// It accepts the same paths as ExpandFS and likewise always uses forward
+// slashes, so "~\docs\x" on Windows gives "docs/x" too. The home directory
+// itself has no entry name and is an error, as are paths outside it.
+func ExpandArchivePath(path string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	name, err := expandFS(dir, path)
+	if err != nil {
+		return "", err
+	}
+	if name == "." {
+		return "", fmt.Errorf("%q is the home directory itself", path)
+	}
+
+	return name, nil
+}
+
+// ExpandSandboxed maps an archive entry name beneath sandboxRoot, treating
+// sandboxRoot as the home directory: "~/docs/x" and "docs/x" both become
+// <sandboxRoot>/docs/x. An error is returned if the result would escape
+// sandboxRoot, as for "~/../../etc/passwd", and for absolute entry names,
+// so that a malicious archive cannot write outside the sandbox.
+func ExpandSandboxed(path, sandboxRoot string) (string, error) {
+	rest := path
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if ok {
+		rest = path[1:]
+	} else if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return "", fmt.Errorf("%q is absolute", path)
+	}
+
+	root := filepath.Clean(sandboxRoot)
+	result := filepath.Join(root, rest)
+	rel, err := filepath.Rel(root, result)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q escapes the sandbox", path)
+	}
+
+	return result, nil
+}
+
+func expandFS(dir, path string) (string, error) {
+	if path == "" || (path[0] != '~' && !filepath.IsAbs(path)) {
+		return "", fmt.Errorf("%q is not relative to the home directory", path)
+	}
+
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if ok {
+		path = filepath.Join(dir, path[1:])
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", err
+	}
+
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") || !fs.ValidPath(rel) {
+		return "", fmt.Errorf("%q is outside the home directory", path)
+	}
+
+	return rel, nil
+}
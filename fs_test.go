@@ -0,0 +1,183 @@
+package homedir
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExpandFS(t *testing.T) {
+	home := nativePath("/home/bob")
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"~", ".", false},
+		{"~/", ".", false},
+		{"~/foo/bar", "foo/bar", false},
+		{"~/foo/../bar", "bar", false},
+		{filepath.Join(home, "foo", "bar"), "foo/bar", false},
+		{"~/..", "", true},
+		{"~/../alice", "", true},
+		{nativePath("/etc/passwd"), "", true},
+		{"foo/bar", "", true},
+		{"", "", true},
+		{"~alice/foo", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := expandFS(home, tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+
+	home := t.TempDir()
+	defer patchEnv("HOME", home)()
+	defer patchEnv("USERPROFILE", home)()
+	if err := os.MkdirAll(filepath.Join(home, "foo"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "foo", "bar"), []byte("baz"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	fsys, err := DirFS()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	name, err := ExpandFS("~/foo/bar")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "baz" {
+		t.Fatalf("expected baz got %q", data)
+	}
+}
+
+func TestExpandExisting(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	fsys := fstest.MapFS{
+		".config/app.toml": &fstest.MapFile{Data: []byte("x = 1\n")},
+		"logs":             &fstest.MapFile{Mode: fs.ModeDir | 0755},
+	}
+
+	cases := []struct {
+		Input    string
+		Output   string
+		NotExist bool
+	}{
+		{"~", home, false},
+		{"~/.config/app.toml", filepath.Join(home, ".config", "app.toml"), false},
+		{"~/.config", filepath.Join(home, ".config"), false},
+		{filepath.Join(home, "logs"), filepath.Join(home, "logs"), false},
+		{"~/missing", "", true},
+		{"~/../alice", "", false},
+		{"relative", "", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandExisting(fsys, tc.Input)
+		if (err != nil) != (tc.Output == "") {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if errors.Is(err, fs.ErrNotExist) != tc.NotExist {
+			t.Fatalf("Input: %#v\n\nunexpected error %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandArchivePath(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"~/docs/x", "docs/x"},
+		{"~//docs/./x/", "docs/x"},
+		{filepath.Join(home, "docs", "x"), "docs/x"},
+		{"~", ""},
+		{"~/", ""},
+		{"~/../alice/x", ""},
+		{nativePath("/etc/passwd"), ""},
+		{"docs/x", ""},
+	}
+	if runtime.GOOS == "windows" {
+		cases = append(cases,
+			struct{ Input, Output string }{`~\docs\x`, "docs/x"},
+			struct{ Input, Output string }{`C:\home\bob\docs\x`, "docs/x"},
+			struct{ Input, Output string }{`D:\home\bob\docs\x`, ""},
+		)
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandArchivePath(tc.Input)
+		if (err != nil) != (tc.Output == "") {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandSandboxed(t *testing.T) {
+	root := nativePath("/tmp/extract")
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"~", root},
+		{"~/docs/x", filepath.Join(root, "docs", "x")},
+		{"docs/x", filepath.Join(root, "docs", "x")},
+		{"~/a/../b", filepath.Join(root, "b")},
+		{"~/../../etc", ""},
+		{"~/..", ""},
+		{"../etc/passwd", ""},
+		{"a/../../etc", ""},
+		{nativePath("/etc/passwd"), ""},
+		{"~alice/x", ""},
+		{"~/..foo", filepath.Join(root, "..foo")},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandSandboxed(tc.Input, root)
+		if (err != nil) != (tc.Output == "") {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package homedir
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzExpand(f *testing.F) {
+	for _, seed := range []string{
+		"", "~", "~/", "~/foo", `~\foo`, "~~", "~~/foo", "~foo/bar",
+		"/abs/path", "rel/path", "~/\x00", "\x00~", "~/../..", "~//foo///bar",
+	} {
+		f.Add(seed)
+	}
+
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	defer patchEnv(OverrideEnv, "")()
+	DisableCache = true
+	defer func() { DisableCache = false }()
+
+	f.Fuzz(func(t *testing.T, input string) {
+		actual, expanded, err := ExpandReport(input)
+		if err != nil {
+			if expanded || actual != "" {
+				t.Fatalf("Input: %#v\n\nfailed with output %#v", input, actual)
+			}
+			return
+		}
+
+		if !expanded {
+			if actual != input {
+				t.Fatalf("Input: %#v\n\nnot round-tripped: %#v", input, actual)
+			}
+			return
+		}
+
+		if input[0] != '~' {
+			t.Fatalf("Input: %#v\n\nexpanded without a tilde", input)
+		}
+		if strings.HasPrefix(actual, "~") {
+			t.Fatalf("Input: %#v\n\ntilde left in %#v", input, actual)
+		}
+		if expected := filepath.Join(home, input[1:]); actual != expected {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, expected %#v", input, actual, expected)
+		}
+	})
+}
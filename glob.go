@@ -0,0 +1,137 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// ExpandGlob expands a leading `~` of pattern like Expand and returns the
+// paths matching the result, in lexical order. Without a `**` element the
+// pattern is matched with filepath.Glob. A `**` element matches zero or
+// more directories, so "~/photos/**/*.jpg" matches "~/photos/a.jpg" as well
+// as "~/photos/2020/june/b.jpg", and a trailing `**` matches everything
+// below a directory. Only real directories are descended into, not
+// symlinks, so cyclic links cannot make it loop. `**` within an element, as
+// in "a**b", is the same as `*`.
+//
+// The home directory is resolved once and taken literally, so that glob
+// metacharacters in it, as in /tmp/x[1], match only themselves. As with
+// filepath.Glob, the only
+// possible error besides those of Expand is filepath.ErrBadPattern;
+// directories that cannot be read are skipped.
+func ExpandGlob(pattern string) ([]string, error) {
+	tilde, err := hasTilde(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// The home directory is a literal path, not part of the pattern, so
+	// only the elements after the `~` are matched
+	var root, rest string
+	if tilde {
+		if root, err = Expand("~"); err != nil {
+			return nil, err
+		}
+		rest = pattern[1:]
+	} else {
+		vol := filepath.VolumeName(pattern)
+		root, rest = vol, pattern[len(vol):]
+		if rest != "" && os.IsPathSeparator(rest[0]) {
+			root += string(filepath.Separator)
+		}
+		if root == "" {
+			root = "."
+		}
+	}
+
+	segments := strings.FieldsFunc(rest, func(r rune) bool { return r < 0x80 && os.IsPathSeparator(uint8(r)) })
+	hasDoubleStar := false
+	for _, segment := range segments {
+		if segment == "**" {
+			hasDoubleStar = true
+		} else if _, err := filepath.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+	if !hasDoubleStar {
+		if tilde {
+			return filepath.Glob(filepath.Join(escapeGlob(root), rest))
+		}
+		return filepath.Glob(pattern)
+	}
+
+	seen := map[string]bool{}
+	var matches []string
+	globSegments(root, segments, func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			matches = append(matches, path)
+		}
+	})
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// globSegments calls match for every path below dir that matches the
+// pattern elements segments, see ExpandGlob. The elements must be valid
+// patterns.
+func globSegments(dir string, segments []string, match func(string)) {
+	if len(segments) == 0 {
+		match(dir)
+		return
+	}
+
+	segment := segments[0]
+	if segment == "**" {
+		globSegments(dir, segments[1:], match)
+	}
+
+	// Like filepath.Glob, look literal elements up directly
+	if segment != "**" && !strings.ContainsAny(segment, `*?[\`) {
+		path := filepath.Join(dir, segment)
+		if _, err := os.Lstat(path); err == nil {
+			globSegments(path, segments[1:], match)
+		}
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if segment == "**" {
+			if entry.IsDir() {
+				globSegments(path, segments, match)
+			} else if len(segments) == 1 {
+				match(path)
+			}
+		} else if ok, _ := filepath.Match(segment, entry.Name()); ok {
+			globSegments(path, segments[1:], match)
+		}
+	}
+}
+
+// escapeGlob returns path with the characters filepath.Match treats
+// specially escaped, so that it matches only itself. A character class is
+// used rather than a backslash, which is the path separator on Windows.
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		switch {
+		case r == '*' || r == '?' || r == '[':
+			b.WriteByte('[')
+			b.WriteRune(r)
+			b.WriteByte(']')
+		case r == '\\' && runtime.GOOS != "windows":
+			b.WriteString(`\\`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
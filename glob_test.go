@@ -0,0 +1,87 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandGlob(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	for _, name := range []string{
+		"photos/a.jpg",
+		"photos/b.png",
+		"photos/2020/c.jpg",
+		"photos/2020/june/d.jpg",
+		"photos/2021/e.jpg",
+		"docs/f.jpg",
+	} {
+		path := filepath.Join(home, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	// A cyclic link must not be followed by **
+	os.Symlink(filepath.Join(home, "photos"), filepath.Join(home, "photos", "2021", "loop"))
+
+	paths := func(names ...string) []string {
+		var result []string
+		for _, name := range names {
+			result = append(result, filepath.Join(home, filepath.FromSlash(name)))
+		}
+		return result
+	}
+
+	cases := []struct {
+		Input  string
+		Output []string
+		Err    bool
+	}{
+		{"~/photos/*.jpg", paths("photos/a.jpg"), false},
+		{"~/photos/**/*.jpg", paths("photos/2020/c.jpg", "photos/2020/june/d.jpg", "photos/2021/e.jpg", "photos/a.jpg"), false},
+		{"~/**/june", paths("photos/2020/june"), false},
+		{"~/photos/**/**/d.jpg", paths("photos/2020/june/d.jpg"), false},
+		{"~/photos/20*/**/*.jpg", paths("photos/2020/c.jpg", "photos/2020/june/d.jpg", "photos/2021/e.jpg"), false},
+		{filepath.Join(home, "docs", "**"), paths("docs", "docs/f.jpg"), false},
+		{"~/**/*.gif", nil, false},
+		{"~/photos/**/[", nil, true},
+		{"~bob/**", nil, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandGlob(tc.Input)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if !reflect.DeepEqual(actual, tc.Output) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	// Metacharacters in the home directory are not pattern syntax
+	odd := filepath.Join(t.TempDir(), "x[1]")
+	if err := os.MkdirAll(filepath.Join(odd, "sub"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(odd, "sub", "a.txt"), nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer patchHome(odd)()
+	for _, input := range []string{"~/sub/*.txt", "~/**/*.txt"} {
+		actual, err := ExpandGlob(input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", input, err)
+		}
+		if expected := []string{filepath.Join(odd, "sub", "a.txt")}; !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", input, actual)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build homedir_test
+
+package homedir
+
+// hermeticBuild is set when the package is compiled with the homedir_test
+// build tag, see TestEnv.
+const hermeticBuild = true
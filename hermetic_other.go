@@ -0,0 +1,6 @@
+//go:build !homedir_test
+
+package homedir
+
+// hermeticBuild is only set by the homedir_test build tag, see TestEnv.
+const hermeticBuild = false
@@ -0,0 +1,49 @@
+//go:build homedir_test
+
+package homedir
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// Run with: go test -tags homedir_test -run TestHermetic
+func TestHermetic(t *testing.T) {
+	home := nativePath("/hermetic/home")
+	defer patchEnv(TestEnv, home)()
+	defer patchEnv(OverrideEnv, nativePath("/override"))()
+	defer patchHome(nativePath("/home/bob"))()
+
+	dir, source, err := DirSource()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != home || source != "env:"+TestEnv {
+		t.Fatalf("expected %v from env:%s got %v from %v", home, TestEnv, dir, source)
+	}
+
+	if actual, err := Expand("~/x"); err != nil || actual != filepath.Join(home, "x") {
+		t.Fatalf("expected %v got %v, %v", filepath.Join(home, "x"), actual, err)
+	}
+
+	// Changing the variable takes effect immediately
+	other := nativePath("/hermetic/other")
+	defer patchEnv(TestEnv, other)()
+	if actual, err := Expand("~/x"); err != nil || actual != filepath.Join(other, "x") {
+		t.Fatalf("expected %v got %v, %v", filepath.Join(other, "x"), actual, err)
+	}
+
+	defer patchEnv(TestEnv, "")()
+	if dir, err := Dir(); !errors.Is(err, ErrNoHomeDir) {
+		t.Fatalf("expected ErrNoHomeDir got %v, %v", dir, err)
+	}
+
+	// DirWithEnv applies the same rule to the environment it is given
+	if dir, err := DirWithEnv([]string{TestEnv + "=" + home, "HOME=" + nativePath("/home/bob")}); err != nil || dir != home {
+		t.Fatalf("expected %v got %v, %v", home, dir, err)
+	}
+	if dir, err := DirWithEnv([]string{"HOME=" + nativePath("/home/bob")}); !errors.Is(err, ErrNoHomeDir) {
+		t.Fatalf("expected ErrNoHomeDir got %v, %v", dir, err)
+	}
+}
@@ -0,0 +1,117 @@
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Home resolves and expands the current user's home directory with a
+// configuration and a cache of its own. Its methods neither read nor change
+// the package-level configuration or caches, so a Go plugin loaded with
+// plugin.Open, which shares that state with the host program and with every
+// other plugin, can expand `~` with its own home layout without calling any
+// Set* function:
+//
+//	var home = &homedir.Home{EnvChain: []string{"PLUGIN_HOME", "HOME"}}
+//
+//	path, err := home.Expand("~/.plugin/config")
+//
+// A Home is not an isolated instance of the whole package. It only covers
+// Dir, Expand and Collapse, and finds the home directory in the environment
+// alone, keeping it until Reset. The discovery strategy, user method order,
+// home template, unusable homes, cache TTL, idle timeout and size, logger
+// and every function other than these methods, such as User and DirFor,
+// remain process-wide, and a plugin that changes them affects the host and
+// every other plugin.
+//
+// A Home must not be copied after first use.
+type Home struct {
+	// Path, if set, is the home directory and no discovery takes place.
+	Path string
+
+	// EnvChain is the ordered list of environment variables consulted
+	// when Path is empty, with the same syntax as SetDirEnvChain. If it is
+	// also empty the platform's default chain is used, whatever
+	// SetDirEnvChain was set to.
+	EnvChain []string
+
+	// PostProcessor, if set, is applied to every expanded path, like the
+	// function set with SetExpandPostProcessor.
+	PostProcessor func(string) string
+
+	mu     sync.Mutex
+	cached string
+}
+
+// Dir returns the home directory of h. Once discovered it is cached until
+// Reset is called.
+func (h *Home) Dir() (string, error) {
+	if h.Path != "" {
+		return h.Path, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cached != "" {
+		return h.cached, nil
+	}
+
+	chain := h.EnvChain
+	if len(chain) == 0 {
+		chain = defaultDirEnvChain()
+	}
+
+	for _, key := range chain {
+		if home := envChainValue(key, os.Getenv); home != "" && filepath.IsAbs(home) {
+			h.cached = filepath.Clean(home)
+			return h.cached, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s are blank or not absolute", ErrNoHomeDir, strings.Join(chain, ", "))
+}
+
+// Reset forgets the home directory cached by h.
+func (h *Home) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cached = ""
+}
+
+// Expand is like the package-level Expand but uses the home directory and
+// post-processor of h.
+func (h *Home) Expand(path string) (string, error) {
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if !ok {
+		return path, nil
+	}
+
+	dir, err := h.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	result := joinHome(dir, path[1:])
+	if h.PostProcessor != nil {
+		result = h.PostProcessor(result)
+	}
+	return result, nil
+}
+
+// Collapse is like the package-level Collapse but uses the home directory
+// of h.
+func (h *Home) Collapse(path string) (string, error) {
+	if path == "" || !filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	dir, err := h.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return collapse(dir, path, caseInsensitiveFS()), nil
+}
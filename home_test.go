@@ -0,0 +1,69 @@
+package homedir
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHomeInstances(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	defer patchHome(nativePath("/home/shared"))()
+
+	host := &Home{Path: nativePath("/home/host")}
+	plugin := &Home{
+		EnvChain:      []string{"HOMEDIR_TEST_PLUGIN_HOME"},
+		PostProcessor: strings.ToUpper,
+	}
+	defer patchEnv("HOMEDIR_TEST_PLUGIN_HOME", nativePath("/opt/plugin/"))()
+
+	// Changing the package-level configuration affects neither instance
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	SetExpandPostProcessor(func(string) string { return "global" })
+
+	cases := []struct {
+		Home   *Home
+		Input  string
+		Output string
+	}{
+		{host, "~/x", filepath.Join(nativePath("/home/host"), "x")},
+		{host, "~", nativePath("/home/host")},
+		{host, "/etc", "/etc"},
+		{plugin, "~/x", strings.ToUpper(filepath.Join(nativePath("/opt/plugin"), "x"))},
+		{plugin, "rel", "rel"},
+	}
+
+	for _, tc := range cases {
+		actual, err := tc.Home.Expand(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	if actual, err := host.Collapse(filepath.Join(nativePath("/home/host"), "x")); err != nil || actual != filepath.Join("~", "x") {
+		t.Fatalf("expected ~/x got %v, %v", actual, err)
+	}
+
+	// The plugin keeps its home until it is reset
+	defer patchEnv("HOMEDIR_TEST_PLUGIN_HOME", "")()
+	if dir, err := plugin.Dir(); err != nil || dir != nativePath("/opt/plugin") {
+		t.Fatalf("expected cached home got %v, %v", dir, err)
+	}
+	plugin.Reset()
+	if dir, err := plugin.Dir(); !errors.Is(err, ErrNoHomeDir) {
+		t.Fatalf("expected ErrNoHomeDir got %v, %v", dir, err)
+	}
+
+	// Without Path or EnvChain the default chain is read, ignoring the
+	// package-level chain, override and default
+	DefaultDir = nativePath("/var/empty")
+	defer patchEnv(OverrideEnv, nativePath("/opt/override"))()
+	if dir, err := new(Home).Dir(); err != nil || dir != nativePath("/home/shared") {
+		t.Fatalf("expected %v got %v, %v", nativePath("/home/shared"), dir, err)
+	}
+}
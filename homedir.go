@@ -1,9 +1,25 @@
+// Package homedir detects the home directory of the current user without
+// cgo and expands `~` in paths.
+//
+// The package is a drop-in replacement for github.com/mitchellh/go-homedir:
+// Dir, Expand, DisableCache and Reset have the same signatures and
+// semantics, Expand returns the same "cannot expand user-specific home dir"
+// error for `~user`, and on Unix Dir consults HOME before any command. Only
+// the import path needs to change. On Windows the environment chain starts
+// with USERPROFILE rather than HOME; call
+//
+//	homedir.SetDirEnvChain([]string{"HOME", "USERPROFILE", "HOMEDRIVE+HOMEPATH"})
+//
+// to keep the order of mitchellh/go-homedir. The messages of other errors
+// of Dir may differ.
 package homedir
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,48 +28,318 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 )
 
 // DisableCache will disable caching of the home directory. Caching is enabled
 // by default.
 var DisableCache bool
 
+// RejectRootHome makes Dir() treat a passwd home directory of "/" as
+// missing and continue with the remaining discovery methods. This is
+// common for uid 0 in minimal containers (e.g. `root:x:0:0:root:/:/bin/sh`),
+// where using "/" would turn "~/config" into "/config". It is disabled by
+// default, in which case "/" is returned like any other home directory.
+var RejectRootHome bool
+
+// AllowJoinEscape makes Join accept segments whose `..` elements lead out of
+// the home directory. It is disabled by default.
+var AllowJoinEscape bool
+
+// RequireTag makes ExpandTagged reject values that lack the tag instead of
+// returning them as-is. It is disabled by default.
+var RequireTag bool
+
+// HomesEnv is the environment variable consulted by AllHomeDirs for
+// additional home directories. It holds a list separated by
+// os.PathListSeparator, like PATH.
+var HomesEnv = "HOMES"
+
 var homedirCache string
+var homedirSource string
 var userCache string
+var userSource string
+var userDirCache = map[string]string{}
+var userDirCacheOrder []string
+var qualifiedUserCache = map[string]string{}
+var expandCache = map[string]string{}
+var expandCacheOrder []string
+var maxCacheEntries = 256
+var dirEnvChain = defaultDirEnvChain()
+var defaultUserMethodOrder = []string{"env", "whoami", "id"}
+var userMethodOrder = defaultUserMethodOrder
+var useEffectiveUID bool
 var whoamiBypass bool
+var expandPostProcessor func(string) string
+var passwdCache *Passwd
+var homeTemplate string
+var shellFallbackEnv []string
+var discoveryStrategy = "env-first"
+var defaultUnusableHomes = []string{"/nonexistent", "/dev/null"}
+var unusableHomes = defaultUnusableHomes
+var minUID int
+var crossPlatformEnv bool
+var preferStdlib bool
+var logger func(msg string, keyvals ...interface{})
+var cacheTTL time.Duration
+var homedirCachedAt time.Time
+var userCachedAt time.Time
+var userDirCachedAt = map[string]time.Time{}
+var userDirUsedAt = map[string]time.Time{}
+var cacheIdleTimeout time.Duration
 var cacheLock sync.RWMutex
 
+// now returns the current time. It is a variable so that tests can control
+// cache expiry.
+var now = time.Now
+
+// stdlibHomeDir is os.UserHomeDir, see SetPreferStdlib. It is a variable so
+// that tests can tell the two code paths apart.
+var stdlibHomeDir = os.UserHomeDir
+
+// idUserRe matches the user name in the output of id. The name is anything
+// up to the closing parenthesis so that names containing dots, hyphens or
+// non-ASCII characters are matched too.
+var idUserRe = regexp.MustCompile(`uid=\d+\(([^)]+)\)`)
+
+// run executes the named command and returns what it wrote to stdout. If env
+// is non-nil it is used as the environment of the command, otherwise the
+// environment of the current process is inherited. It is a variable so that
+// tests can substitute canned command output.
+var run = func(env []string, name string, arg ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(name, arg...)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	return stdout.Bytes(), err
+}
+
+// runStream starts the named command and returns its stdout, to be read as
+// the command produces it, and a function that waits for the command to
+// exit once stdout has been read. The command is killed when ctx is done. It
+// is a variable so that tests can substitute slow command output.
+var runStream = func(ctx context.Context, name string, arg ...string) (io.Reader, func() error, error) {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	return stdout, cmd.Wait, nil
+}
+
+// Reset clears every cache of the package, forcing the next call to Dir,
+// User, DirFor, DirForAll, PasswdEntry, UserQualified, UserPrincipalName or
+// Expand to re-detect everything: the home directory and its source, the
+// user name and its source, the home directories of other users, the passwd entry, the
+// qualified user names and the expanded paths. Configuration changed with
+// the Set* functions and the exported variables is kept; see Snapshot to
+// restore that as well. Home instances have caches of their own, see
+// Home.Reset.
+func Reset() {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	clearDirCacheLocked()
+	userCache = ""
+	userSource = ""
+	userCachedAt = time.Time{}
+	userDirCache = map[string]string{}
+	userDirCacheOrder = nil
+	userDirCachedAt = map[string]time.Time{}
+	userDirUsedAt = map[string]time.Time{}
+	qualifiedUserCache = map[string]string{}
+}
+
+// clearDirCacheLocked forgets the cached home directory and everything
+// derived from it. The caller must hold cacheLock for writing.
+func clearDirCacheLocked() {
+	homedirCache = ""
+	homedirSource = ""
+	homedirCachedAt = time.Time{}
+	expandCache = map[string]string{}
+	expandCacheOrder = nil
+	passwdCache = nil
+}
+
 // User returns the executing user name.
 //
 // This uses an OS-specific method for discovering the user name.
 // An error is returned if the user name cannot be detected.
 func User() (string, error) {
+	name, _, err := UserSource()
+	return name, err
+}
+
+// UserSource is like User but also reports which method produced the user
+// name, which helps to diagnose why an unexpected user is reported, for
+// instance in a container with a stale USER. The source is one of
+//
+//	env:USER        the USER environment variable
+//	whoami          the output of whoami
+//	id              the output of id
+//	getent          the passwd database
+//	env:USERNAME    the USERNAME environment variable (Windows)
+//
+// The source of the cached user name is remembered alongside it.
+func UserSource() (name string, source string, err error) {
 	if !DisableCache {
 		cacheLock.RLock()
-		cached := userCache
+		cached, cachedSource := userCache, userSource
+		fresh := cacheFresh(userCachedAt)
 		cacheLock.RUnlock()
-		if cached != "" {
-			return cached, nil
+		if cached != "" && fresh {
+			return cached, cachedSource, nil
 		}
 	}
 
 	cacheLock.Lock()
 	defer cacheLock.Unlock()
 
-	var result string
-	var err error
+	name, source, err = discoverUser()
+	if err != nil {
+		return "", "", err
+	}
+	userCache = name
+	userSource = source
+	userCachedAt = now()
+	return name, source, nil
+}
+
+// discoverUser returns the executing user name and its source without
+// consulting the cache. The caller must hold cacheLock; discovery only reads
+// the configuration.
+func discoverUser() (string, string, error) {
 	if runtime.GOOS == "windows" {
-		result, err = userWindows()
-	} else {
-		// Unix-like system, so just assume Unix
-		result, err = userUnix()
+		return userWindows()
 	}
+	// Unix-like system, so just assume Unix
+	return userUnix()
+}
 
+// UserEquals reports whether name is the executing user name as returned
+// by User(). User names are compared without regard to case on Windows and
+// macOS, matching how those systems resolve accounts, and exactly elsewhere.
+func UserEquals(name string) (bool, error) {
+	user, err := User()
 	if err != nil {
-		return "", err
+		return false, err
 	}
-	userCache = result
-	return result, nil
+
+	return userEquals(user, name, caseInsensitiveFS()), nil
+}
+
+// UserConsistent reports whether the USER environment variable names the
+// account of the real uid, as recorded in the passwd database, to detect
+// tampered or inherited environments such as after sudo or in setuid
+// programs. On Windows USERNAME is compared with the account of the process
+// token instead, without regard to case. An unset variable is consistent,
+// since it cannot be misleading. An error is returned if the account cannot
+// be determined.
+func UserConsistent() (bool, error) {
+	if runtime.GOOS == "windows" {
+		name := os.Getenv("USERNAME")
+		if name == "" {
+			return true, nil
+		}
+		qualified, err := UserQualified()
+		if err != nil {
+			return false, err
+		}
+		if i := strings.LastIndexByte(qualified, '\\'); i >= 0 {
+			qualified = qualified[i+1:]
+		}
+		return userEquals(name, qualified, true), nil
+	}
+
+	name := os.Getenv("USER")
+	if name == "" {
+		return true, nil
+	}
+	entry, err := passwdLookup(strconv.Itoa(os.Getuid()))
+	if err != nil {
+		return false, err
+	}
+	return name == entry.Name, nil
+}
+
+// userEquals compares two user names. fold selects a case insensitive
+// comparison.
+func userEquals(a, b string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// ErrNoHomeDir is returned by Dir() when every discovery method has failed
+// to produce a trustworthy home directory. In particular, the shell fallback
+// is not trusted when it merely reports the current working directory; a home
+// directory that happens to be the working directory can't be told apart and
+// is rejected as well.
+var ErrNoHomeDir = errors.New("no home directory found")
+
+// ErrUnsupportedPlatform is returned by functions that are not available on
+// the current operating system.
+var ErrUnsupportedPlatform = errors.New("not supported on " + runtime.GOOS)
+
+// ErrNotFound is returned by FindUpToHome if no directory on the way up has
+// an entry of the given name.
+var ErrNotFound = errors.New("not found")
+
+// ErrRestrictedAccount is returned by DirFor and the functions built on it
+// for system and service accounts once SetMinUID has enabled the policy.
+var ErrRestrictedAccount = errors.New("refused to expand restricted account")
+
+// ErrUnknownUser is returned by DirFor and the functions built on it for
+// users the passwd database, or on Windows the profile list, has no entry
+// for.
+var ErrUnknownUser = errors.New("unknown user")
+
+// ErrInvalidUsername is returned by DirFor and the functions built on it for
+// user names that are rejected before any lookup, see DirFor.
+var ErrInvalidUsername = errors.New("invalid user name")
+
+// DefaultDir, if set, is returned by Dir() before any discovery is
+// attempted. It is empty by default and intended to be set at build time
+// for hermetic builds and tests:
+//
+//	go build -ldflags "-X github.com/marcopeereboom/go-homedir.DefaultDir=/build/home"
+//
+// OverrideEnv still takes precedence over DefaultDir.
+var DefaultDir string
+
+// OverrideEnv is the environment variable that, when set to a non-empty
+// value, is returned by Dir() on every platform, bypassing the cache and all
+// discovery. It is intended for test harnesses of programs using this
+// package and should not be relied on in production.
+//
+// A home directory pinned with OverrideEnv, DefaultDir or the homedir_test
+// build tag affects every function of the package that expands, collapses
+// or is derived from the home directory. In particular ConfigDir, CacheDir,
+// DataDir and SSHDir then ignore XDG_CONFIG_HOME, XDG_CACHE_HOME,
+// XDG_DATA_HOME, AppData, LocalAppData and USERPROFILE and are placed
+// beneath the pinned home directory, as they would be by default.
+const OverrideEnv = "GO_HOMEDIR_OVERRIDE"
+
+// TestEnv is the only source of the home directory in programs built with
+// the homedir_test build tag:
+//
+//	go test -tags homedir_test ./...
+//
+// In such builds Dir() returns the value of TestEnv, which must be set, and
+// never consults the cache, OverrideEnv, DefaultDir or any other discovery
+// method. This gives test binaries a hermetic home directory regardless of
+// the HOME of the CI machine, while builds without the tag are unaffected.
+const TestEnv = "GO_HOMEDIR_TEST"
+
+// homePinned reports whether Dir() returns a fixed home directory without
+// any discovery, see OverrideEnv.
+func homePinned() bool {
+	return hermeticBuild || os.Getenv(OverrideEnv) != "" || DefaultDir != ""
 }
 
 // Dir returns the home directory for the executing user.
@@ -61,167 +347,1972 @@ func User() (string, error) {
 // This uses an OS-specific method for discovering the home directory.
 // An error is returned if a home directory cannot be detected.
 func Dir() (string, error) {
+	dir, _, err := DirSource()
+	return dir, err
+}
+
+// DirForms returns the home directory both as returned by Dir and with
+// filepath.ToSlash applied, from a single resolution. On Unix the two are
+// identical, while on Windows C:\Users\bob becomes C:/Users/bob, as used by
+// protocols and formats that expect forward slashes.
+func DirForms() (native string, posix string, err error) {
+	native, err = Dir()
+	if err != nil {
+		return "", "", err
+	}
+
+	return native, filepath.ToSlash(native), nil
+}
+
+// DirBytes is like Dir but returns the home directory as a new byte slice
+// that the caller owns and may append to. Building many paths by appending
+// to a reused buffer avoids the allocations of filepath.Join:
+//
+//	home, err := homedir.DirBytes()
+//	...
+//	buf := make([]byte, 0, len(home)+64)
+//	for _, name := range names {
+//		buf = append(append(append(buf[:0], home...), filepath.Separator), name...)
+//		// use buf, copying it if it must outlive the iteration
+//	}
+//
+// The suffixes are not cleaned, so this is only equivalent to Join for plain
+// names. DirBytes itself allocates once per call.
+func DirBytes() ([]byte, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(dir), nil
+}
+
+// DirSource is like Dir but also reports how the home directory was
+// discovered. source is one of
+//
+//	env:GO_HOMEDIR_TEST       the only source with the homedir_test tag, see TestEnv
+//	env:GO_HOMEDIR_OVERRIDE   the test override, see OverrideEnv
+//	default                   the build-time DefaultDir
+//	stdlib                    os.UserHomeDir, see SetPreferStdlib
+//	env:HOME                  the HOME environment variable
+//	systemd                   the systemd user manager environment
+//	getent                    the passwd database
+//	pw                        `pw usershow -P` (FreeBSD and DragonFly, not OpenBSD)
+//	shell                     the output of `sh -c "cd && pwd"`
+//	template                  the last resort set with SetHomeTemplate
+//	env:USERPROFILE           the USERPROFILE environment variable (Windows)
+//	env:HOMEDRIVE+HOMEPATH    HOMEDRIVE and HOMEPATH combined (Windows)
+//	env:HOMESHARE+HOMEPATH    HOMESHARE and HOMEPATH combined (Windows)
+//
+// The source of the cached home directory is remembered alongside it.
+func DirSource() (dir string, source string, err error) {
+	if dir, source, ok, err := pinnedDir(); ok {
+		return dir, source, err
+	}
+
 	if !DisableCache {
 		cacheLock.RLock()
-		cached := homedirCache
+		cached, cachedSource := homedirCache, homedirSource
+		fresh := cacheFresh(homedirCachedAt)
 		cacheLock.RUnlock()
-		if cached != "" {
-			return cached, nil
+		if cached != "" && fresh {
+			return cached, cachedSource, nil
 		}
 	}
 
 	cacheLock.Lock()
 	defer cacheLock.Unlock()
 
-	var result string
-	var err error
-	if runtime.GOOS == "windows" {
-		result, err = dirWindows()
+	dir, source, err = discoverDir()
+	if err != nil {
+		return "", "", err
+	}
+	if dir != homedirCache {
+		// Expansions of an expired home directory are stale
+		expandCache = map[string]string{}
+		expandCacheOrder = nil
+	}
+	homedirCache = dir
+	homedirSource = source
+	homedirCachedAt = now()
+	return dir, source, nil
+}
+
+// pinnedDir returns the home directory pinned with the homedir_test build
+// tag, OverrideEnv or DefaultDir and its source, see DirSource. ok is false
+// if the home directory isn't pinned and must be discovered.
+func pinnedDir() (dir, source string, ok bool, err error) {
+	if hermeticBuild {
+		if dir := os.Getenv(TestEnv); dir != "" {
+			return dir, "env:" + TestEnv, true, nil
+		}
+		return "", "", true, fmt.Errorf("%w: %s must be set in builds with the homedir_test tag", ErrNoHomeDir, TestEnv)
+	}
+	if override := os.Getenv(OverrideEnv); override != "" {
+		return override, "env:" + OverrideEnv, true, nil
+	}
+	if DefaultDir != "" {
+		return DefaultDir, "default", true, nil
+	}
+	return "", "", false, nil
+}
+
+// discoverDir returns the home directory and its source, see DirSource,
+// without consulting the cache, OverrideEnv or DefaultDir. The caller must
+// hold cacheLock; discovery only reads the configuration.
+func discoverDir() (dir string, source string, err error) {
+	if home := stdlibHome(); home != "" {
+		dir, source = home, "stdlib"
+	} else if runtime.GOOS == "windows" {
+		dir, source, err = dirWindows()
 	} else {
 		// Unix-like system, so just assume Unix
-		result, err = dirUnix()
+		dir, source, err = dirUnix()
+	}
+
+	if err == nil && unusableHome(dir) {
+		if logger != nil {
+			logger("home is unusable", "home", dir)
+		}
+		err = fmt.Errorf("%w: home directory %q is unusable, see SetUnusableHomes", ErrNoHomeDir, dir)
+	}
+
+	if err != nil && homeTemplate != "" {
+		if home, tmplErr := templateHome(); tmplErr == nil {
+			if logger != nil {
+				logger("using home template", "home", home)
+			}
+			dir, source, err = home, "template", nil
+		}
 	}
 
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	homedirCache = result
-	return result, nil
+	return dir, source, nil
 }
 
-func userUnix() (string, error) {
-	// First prefer the USER environmental variable
-	if user := os.Getenv("USER"); user != "" {
-		return user, nil
+// stdlibHome returns the cleaned result of os.UserHomeDir if SetPreferStdlib
+// enabled it and it is absolute, and "" otherwise. The caller must hold
+// cacheLock.
+func stdlibHome() string {
+	if !preferStdlib {
+		return ""
 	}
 
-	// If that fails, try whoami
-	var stdout bytes.Buffer
-	cmd := exec.Command("whoami")
-	cmd.Stdout = &stdout
-	if err := cmd.Run(); err != nil {
-		// If "whoami" is missing, ignore it
-		if err == exec.ErrNotFound {
-			return "", err
+	home, err := stdlibHomeDir()
+	if err != nil || !filepath.IsAbs(home) {
+		if logger != nil {
+			logger("os.UserHomeDir failed", "home", home, "err", err)
 		}
+		return ""
+	}
+	return filepath.Clean(home)
+}
+
+// stdlibHomeEnv returns the environment variable os.UserHomeDir reads.
+func stdlibHomeEnv() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "USERPROFILE"
+	case "plan9":
+		return "home"
+	}
+	return "HOME"
+}
+
+// SetUnusableHomes sets the home directories that Dir() treats as no usable
+// home at all, typically those of service accounts such as "/nonexistent".
+// When discovery ends up at one of them, Dir() falls back to the template set
+// with SetHomeTemplate if there is one, and otherwise returns an error
+// wrapping ErrNoHomeDir. The default is "/nonexistent" and "/dev/null".
+// Passing nil restores the default and passing an empty list disables the
+// check. The cached home directory is cleared.
+func SetUnusableHomes(homes []string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if homes == nil {
+		unusableHomes = defaultUnusableHomes
 	} else {
-		result := strings.TrimSpace(stdout.String())
-		if result != "" && !whoamiBypass {
-			return result, nil
+		unusableHomes = make([]string, 0, len(homes))
+		for _, home := range homes {
+			unusableHomes = append(unusableHomes, filepath.Clean(home))
 		}
 	}
+	clearDirCacheLocked()
+}
 
-	// try id
-	cmd = exec.Command("id")
-	cmd.Stdout = &stdout
-	if err := cmd.Run(); err != nil {
-		// If "id" is missing, ignore it
-		if err == exec.ErrNotFound {
+// unusableHome reports whether dir is one of the homes set with
+// SetUnusableHomes. The caller must hold cacheLock.
+func unusableHome(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, home := range unusableHomes {
+		if dir == home {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHomeTemplate sets a template from which Dir() constructs the home
+// directory as a deliberate last resort, when every discovery method has
+// failed. This is meant for minimal containers without HOME, a passwd
+// database or a shell, whose layout the application knows. The placeholders
+// {user} and {uid} are replaced by the values of User() and UserID(), so
+// "/home/{user}" or "/data/{uid}" are typical. The result must be an
+// absolute path, and the template is not used if a placeholder cannot be
+// filled in. The default is "", which disables it. The cached home directory
+// is cleared.
+func SetHomeTemplate(tmpl string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	homeTemplate = tmpl
+	clearDirCacheLocked()
+}
+
+// templateHome returns the home directory built from the template set with
+// SetHomeTemplate. The caller must hold cacheLock.
+func templateHome() (string, error) {
+	result := homeTemplate
+	if strings.Contains(result, "{user}") {
+		user, _, err := discoverUser()
+		if err != nil {
 			return "", err
 		}
+		result = strings.ReplaceAll(result, "{user}", user)
+	}
+	if strings.Contains(result, "{uid}") {
+		if runtime.GOOS == "windows" {
+			return "", ErrUnsupportedPlatform
+		}
+		result = strings.ReplaceAll(result, "{uid}", strconv.Itoa(lookupUID()))
 	}
 
-	r, err := regexp.Compile("uid=\\d+\\((\\w+)\\)")
-	if err != nil {
-		return "", fmt.Errorf("exhausted methods to obtain username")
+	if !filepath.IsAbs(result) {
+		return "", fmt.Errorf("home template %q is not an absolute path", homeTemplate)
 	}
-	sm := r.FindStringSubmatch(stdout.String())
-	if len(sm) != 2 {
-		return "", fmt.Errorf("exhausted methods to obtain username")
+	return filepath.Clean(result), nil
+}
+
+// UserID returns the uid Dir() looks up in the passwd database, the real
+// uid by default, see SetUseEffectiveUID. ErrUnsupportedPlatform is returned
+// on Windows, which has no numeric user IDs.
+func UserID() (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", ErrUnsupportedPlatform
 	}
 
-	return sm[1], nil
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	return strconv.Itoa(lookupUID()), nil
 }
 
-func userWindows() (string, error) {
-	// First prefer the USER environmental variable
-	if user := os.Getenv("USERNAME"); user != "" {
-		return user, nil
+// DirFor returns the home directory of the named user.
+//
+// The home directory is looked up in the passwd database using getent. An
+// error is returned if the user is unknown, and ErrUnsupportedPlatform on
+// Windows, which has no passwd database.
+//
+// The user name may come from untrusted input, such as a `~user` path given
+// to ExpandUser. It is passed to getent as a separate argument and never
+// through a shell. Even so, names that are empty, start with "-" or contain
+// a NUL, a "/", another control character, whitespace or a shell
+// metacharacter such as ";" or "$" are rejected before any lookup, so that
+// they cannot do harm should they ever reach a shell.
+func DirFor(username string) (string, error) {
+	if err := checkUsername(username); err != nil {
+		return "", err
+	}
+
+	if !DisableCache {
+		if cached := cachedUserDir(username); cached != "" {
+			return cached, nil
+		}
+	}
+
+	entry, err := passwdLookup(username)
+	if err != nil {
+		return "", err
+	}
+	if err := checkRestricted(entry); err != nil {
+		return "", err
 	}
+	result := entry.Dir
 
-	return "", fmt.Errorf("exhausted methods to obtain username")
+	cacheLock.Lock()
+	cacheUserDirLocked(username, result)
+	cacheLock.Unlock()
+	return result, nil
 }
 
-// Expand expands the path to include the home directory if the path
-// is prefixed with `~`. If it isn't prefixed with `~`, the path is
-// returned as-is.
-func Expand(path string) (string, error) {
-	if len(path) == 0 {
-		return path, nil
+// shellMeta holds the characters that are special to POSIX shells.
+const shellMeta = ";&|<>()$`\\\"'*?[]{}!#~"
+
+// checkUsername returns an error if username is not safe to look up, see
+// DirFor.
+func checkUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidUsername)
 	}
+	if username[0] == '-' {
+		return fmt.Errorf("%w %q: starts with -", ErrInvalidUsername, username)
+	}
+	for _, r := range username {
+		if r == '/' || unicode.IsControl(r) || unicode.IsSpace(r) || strings.ContainsRune(shellMeta, r) {
+			return fmt.Errorf("%w %q: contains %q", ErrInvalidUsername, username, r)
+		}
+	}
+	return nil
+}
 
-	if path[0] != '~' {
-		return path, nil
+// SetMinUID sets the policy DirFor applies to the accounts it looks up, so
+// that server code expanding user-supplied `~name` paths with ExpandUser
+// cannot be pointed into the directories of system and service accounts.
+// When uid is positive, accounts with a lower uid, a nologin or false shell,
+// or a home set with SetUnusableHomes are refused with an error wrapping
+// ErrRestrictedAccount, and left out of the result of DirForAll. The default
+// of 0 disables the policy. The cached home directories of other users are
+// cleared.
+func SetMinUID(uid int) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	minUID = uid
+	userDirCache = map[string]string{}
+	userDirCacheOrder = nil
+	userDirCachedAt = map[string]time.Time{}
+	userDirUsedAt = map[string]time.Time{}
+}
+
+// checkRestricted returns an error wrapping ErrRestrictedAccount if entry is
+// refused by the policy set with SetMinUID.
+func checkRestricted(entry *Passwd) error {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	if minUID <= 0 {
+		return nil
 	}
 
-	if len(path) > 1 && path[1] != '/' && path[1] != '\\' {
-		return "", errors.New("cannot expand user-specific home dir")
+	reason := ""
+	switch shell := filepath.Base(entry.Shell); {
+	case entry.UID < minUID:
+		reason = fmt.Sprintf("uid %d is below %d", entry.UID, minUID)
+	case shell == "nologin" || shell == "false":
+		reason = "shell " + entry.Shell + " does not allow logins"
+	case unusableHome(entry.Dir):
+		reason = "home " + entry.Dir + " is unusable"
+	default:
+		return nil
 	}
+	return fmt.Errorf("%w %q: %s", ErrRestrictedAccount, entry.Name, reason)
+}
 
-	dir, err := Dir()
-	if err != nil {
-		return "", err
+// DirForUID is like DirFor but looks up the user by uid. The result is not
+// cached.
+func DirForUID(uid int) (string, error) {
+	if uid < 0 {
+		return "", fmt.Errorf("invalid uid %d", uid)
 	}
 
-	return filepath.Join(dir, path[1:]), nil
+	return passwdDir(strconv.Itoa(uid))
 }
 
-func dirUnix() (string, error) {
-	// First prefer the HOME environmental variable
-	if home := os.Getenv("HOME"); home != "" {
-		return home, nil
+// cachedUserDir returns the cached home directory of username, or "" if it
+// isn't cached, has expired or has been idle for too long.
+func cachedUserDir(username string) string {
+	cacheLock.RLock()
+	dir := userDirCache[username]
+	fresh := cacheFresh(userDirCachedAt[username])
+	touch := cacheIdleTimeout > 0 || !lruRecent(userDirCacheOrder, username)
+	cacheLock.RUnlock()
+	if dir == "" || !fresh {
+		return ""
 	}
 
-	// If that fails, try getent
-	var stdout bytes.Buffer
-	cmd := exec.Command("getent", "passwd", strconv.Itoa(os.Getuid()))
-	cmd.Stdout = &stdout
-	if err := cmd.Run(); err != nil {
-		// If "getent" is missing, ignore it
-		if err == exec.ErrNotFound {
-			return "", err
+	if touch {
+		cacheLock.Lock()
+		defer cacheLock.Unlock()
+		sweepIdleUserDirsLocked()
+		if _, ok := userDirCache[username]; !ok || cacheIdle(userDirUsedAt[username]) {
+			return ""
 		}
+		lruTouch(userDirCacheOrder, username)
+		userDirUsedAt[username] = now()
+	}
+	return dir
+}
+
+// cacheUserDirLocked remembers the home directory of username, evicting the
+// least recently used entry once maxCacheEntries entries are cached. The
+// caller must hold cacheLock for writing.
+func cacheUserDirLocked(username, dir string) {
+	if maxCacheEntries <= 0 {
+		return
+	}
+	sweepIdleUserDirsLocked()
+	if _, ok := userDirCache[username]; ok {
+		lruTouch(userDirCacheOrder, username)
 	} else {
-		if passwd := strings.TrimSpace(stdout.String()); passwd != "" {
-			// username:password:uid:gid:gecos:home:shell
-			passwdParts := strings.SplitN(passwd, ":", 7)
-			if len(passwdParts) > 5 {
-				return passwdParts[5], nil
-			}
+		for len(userDirCacheOrder) >= maxCacheEntries {
+			evictUserDirLocked()
 		}
+		userDirCacheOrder = append(userDirCacheOrder, username)
 	}
+	userDirCache[username] = dir
+	userDirCachedAt[username] = now()
+	userDirUsedAt[username] = now()
+}
 
-	// If all else fails, try the shell
-	stdout.Reset()
-	cmd = exec.Command("sh", "-c", "cd && pwd")
-	cmd.Stdout = &stdout
-	if err := cmd.Run(); err != nil {
+// evictUserDirLocked forgets the least recently used home directory of
+// another user. The caller must hold cacheLock for writing.
+func evictUserDirLocked() {
+	username := userDirCacheOrder[0]
+	delete(userDirCache, username)
+	delete(userDirCachedAt, username)
+	delete(userDirUsedAt, username)
+	userDirCacheOrder = userDirCacheOrder[1:]
+}
+
+// sweepIdleUserDirsLocked forgets the home directories of other users that
+// have been idle for longer than the timeout set with SetCacheIdleTimeout.
+// Since the least recently used entries come first, it stops at the first
+// entry still in use. The caller must hold cacheLock for writing.
+func sweepIdleUserDirsLocked() {
+	for len(userDirCacheOrder) > 0 && cacheIdle(userDirUsedAt[userDirCacheOrder[0]]) {
+		evictUserDirLocked()
+	}
+}
+
+// cacheIdle reports whether an entry last used at t has been idle for longer
+// than the timeout set with SetCacheIdleTimeout. The caller must hold
+// cacheLock.
+func cacheIdle(t time.Time) bool {
+	return cacheIdleTimeout > 0 && now().Sub(t) >= cacheIdleTimeout
+}
+
+// SetCacheIdleTimeout makes the cache of the home directories of other
+// users, filled by DirFor and DirForAll, forget entries that haven't been
+// used within d. Idle entries are evicted when they are next looked up and
+// by a sweep whenever a new entry is cached, which bounds the memory of
+// multi-tenant servers whose users come and go without the hard limit of
+// SetMaxCacheEntries. Unlike SetCacheTTL, which limits the age of an entry,
+// every use restarts the timeout. The default of zero disables it.
+func SetCacheIdleTimeout(d time.Duration) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	cacheIdleTimeout = d
+	sweepIdleUserDirsLocked()
+}
+
+// SetMaxCacheEntries bounds the number of entries in the cache of expanded
+// paths and in that of the home directories of other users, each of which
+// evicts its least recently used entry once full. This keeps the memory of
+// long-running servers bounded when they expand many distinct, possibly
+// attacker-influenced `~user` paths. The default is 256 entries; n <= 0
+// turns both caches off. Entries beyond a lowered bound are evicted right
+// away.
+func SetMaxCacheEntries(n int) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	maxCacheEntries = n
+	for len(expandCacheOrder) > 0 && len(expandCacheOrder) > n {
+		delete(expandCache, expandCacheOrder[0])
+		expandCacheOrder = expandCacheOrder[1:]
+	}
+	for len(userDirCacheOrder) > 0 && len(userDirCacheOrder) > n {
+		evictUserDirLocked()
+	}
+}
+
+// lruRecent reports whether key is the most recently used entry of order.
+func lruRecent(order []string, key string) bool {
+	return len(order) > 0 && order[len(order)-1] == key
+}
+
+// lruTouch moves key to the end of order, marking it as the most recently
+// used entry. The search starts at the end, where frequently used keys are.
+func lruTouch(order []string, key string) {
+	for i := len(order) - 1; i >= 0; i-- {
+		if order[i] == key {
+			copy(order[i:], order[i+1:])
+			order[len(order)-1] = key
+			return
+		}
+	}
+}
+
+// SetCacheTTL sets how long cached values are used before they are
+// recomputed on the next access. This applies to the home directory and its
+// expansions, the user name and the home directories of other users looked
+// up with DirFor. The default of zero caches values until Reset is called;
+// DisableCache turns caching off entirely.
+func SetCacheTTL(d time.Duration) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	cacheTTL = d
+}
+
+// cacheFresh reports whether a value cached at t may still be used. The
+// caller must hold cacheLock.
+func cacheFresh(t time.Time) bool {
+	return cacheTTL <= 0 || now().Sub(t) < cacheTTL
+}
+
+// AllHomeDirs returns the home directory reported by Dir() followed by any
+// additional home directories listed in the environment variable named by
+// HomesEnv. Entries are cleaned and duplicates are dropped, keeping the
+// first occurrence. On typical systems the result has a single element.
+func AllHomeDirs() ([]string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{filepath.Clean(dir)}
+	seen := map[string]bool{dirs[0]: true}
+	if HomesEnv == "" {
+		return dirs, nil
+	}
+
+	for _, d := range filepath.SplitList(os.Getenv(HomesEnv)) {
+		if d == "" {
+			continue
+		}
+		d = filepath.Clean(d)
+		if !seen[d] {
+			seen[d] = true
+			dirs = append(dirs, d)
+		}
+	}
+
+	return dirs, nil
+}
+
+// EnsureDir returns the home directory, creating it and any missing parents
+// with permissions perm (before umask) if it doesn't exist. It is a no-op
+// for an existing directory. EnsureDir only ensures the directory exists; it
+// doesn't change the ownership or permissions of an existing directory.
+func EnsureDir(perm os.FileMode) (string, error) {
+	dir, err := Dir()
+	if err != nil {
 		return "", err
 	}
 
-	result := strings.TrimSpace(stdout.String())
-	if result == "" {
-		return "", errors.New("blank output when reading home directory")
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return "", fmt.Errorf("cannot create home directory: %v", err)
+	}
+
+	return dir, nil
+}
+
+// ExpandEnsureParent expands path like Expand and creates the parent
+// directory of the result, along with any missing parents, with permissions
+// perm (before umask). The expanded path is returned; the file itself is not
+// created. Expansion errors are returned as-is, while a failure to create
+// the parent directory is reported as such and wraps the error of
+// os.MkdirAll.
+func ExpandEnsureParent(path string, perm os.FileMode) (string, error) {
+	result, err := Expand(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(result), perm); err != nil {
+		return "", fmt.Errorf("cannot create parent directory: %w", err)
 	}
 
 	return result, nil
 }
 
-func dirWindows() (string, error) {
-	// First prefer the HOME environmental variable
-	if home := os.Getenv("HOME"); home != "" {
-		return home, nil
+// Join returns the home directory with segments joined onto it, like
+// filepath.Join(Dir(), segments...) but without losing the error.
+//
+// Absolute segments, including ones with a volume name on Windows, are
+// rejected. So is a result outside the home directory, such as for
+// Join("..", "alice"), unless AllowJoinEscape is set. `..` elements that stay
+// within the home directory, as in Join("a", "..", "b"), are fine.
+func Join(segments ...string) (string, error) {
+	for _, segment := range segments {
+		if filepath.IsAbs(segment) || filepath.VolumeName(segment) != "" {
+			return "", fmt.Errorf("cannot join absolute path %q onto home directory", segment)
+		}
 	}
 
-	drive := os.Getenv("HOMEDRIVE")
-	path := os.Getenv("HOMEPATH")
-	home := drive + path
-	if drive == "" || path == "" {
-		home = os.Getenv("USERPROFILE")
+	dir, err := Dir()
+	if err != nil {
+		return "", err
 	}
-	if home == "" {
-		return "", errors.New("HOMEDRIVE, HOMEPATH, and USERPROFILE are blank")
+
+	result := filepath.Join(append([]string{dir}, segments...)...)
+	if !AllowJoinEscape {
+		rel, err := filepath.Rel(dir, result)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%q escapes the home directory", filepath.Join(segments...))
+		}
+	}
+
+	return result, nil
+}
+
+// DirWritable reports whether files can be created in the home directory.
+// It tries to create and remove a temporary file there, since permission
+// bits don't tell the whole story with ACLs or read-only mounts. An error is
+// only returned if the home directory cannot be determined; any failure to
+// create the file means it isn't writable.
+func DirWritable() (bool, error) {
+	dir, err := Dir()
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.CreateTemp(dir, ".homedir-probe-*")
+	if err != nil {
+		return false, nil
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true, nil
+}
+
+// DirModTime returns the modification time of the home directory. Errors
+// resolving the home directory are returned as-is, while errors from
+// os.Stat are returned unchanged as *fs.PathError values.
+func DirModTime() (time.Time, error) {
+	dir, err := Dir()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// InvokingUserDir returns the home directory of the user that invoked the
+// process through a privilege escalation tool, or Dir() if there is none.
+//
+// Tools such as sudo leave HOME pointing at the target user's home, which
+// is rarely what the invoking user means by "my home". The following
+// environment variables are consulted in order and the first one that is
+// set wins:
+//
+//	SUDO_USER   user name, set by sudo
+//	DOAS_USER   user name, set by doas
+//	PKEXEC_UID  numeric uid, set by pkexec
+func InvokingUserDir() (string, error) {
+	for _, key := range []string{"SUDO_USER", "DOAS_USER"} {
+		if username := os.Getenv(key); username != "" {
+			return DirFor(username)
+		}
+	}
+
+	if uid := os.Getenv("PKEXEC_UID"); uid != "" {
+		if _, err := strconv.Atoi(uid); err != nil {
+			return "", fmt.Errorf("invalid PKEXEC_UID %q", uid)
+		}
+		return passwdDir(uid)
+	}
+
+	return Dir()
+}
+
+// passwdDir returns the home directory field of the passwd entry matching
+// key, which may be either a user name or a numeric uid.
+func passwdDir(key string) (string, error) {
+	entry, err := passwdLookup(key)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.Dir, nil
+}
+
+// passwdLookup returns the passwd entry matching key, which may be either a
+// user name or a numeric uid. The entry always has a home directory.
+func passwdLookup(key string) (*Passwd, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrUnsupportedPlatform
+	}
+
+	out, err := run(nil, "getent", "passwd", key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no passwd entry for %q: %v", ErrUnknownUser, key, err)
+	}
+
+	entry, err := ParsePasswdLine(outputString(out))
+	if err != nil || entry.Dir == "" {
+		return nil, fmt.Errorf("no home directory in passwd entry for %q", key)
+	}
+
+	return entry, nil
+}
+
+// splitPasswd returns the user name and home directory fields of a passwd
+// line, see ParsePasswdLine. home is empty if the line is malformed.
+func splitPasswd(line string) (name, home string) {
+	entry, err := ParsePasswdLine(line)
+	if err != nil {
+		return "", ""
+	}
+
+	return entry.Name, entry.Dir
+}
+
+// DirForAll returns the home directories of the named users, keyed by user
+// name, using a single getent invocation for all users that aren't cached.
+// Unknown users are absent from the result. The per-user cache used by
+// DirFor is populated as a side effect. User names are checked as by DirFor,
+// except that empty names are ignored.
+func DirForAll(usernames []string) (map[string]string, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrUnsupportedPlatform
+	}
+	for _, username := range usernames {
+		if username == "" {
+			continue
+		}
+		if err := checkUsername(username); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]string, len(usernames))
+	var missing []string
+	for _, username := range usernames {
+		if username == "" {
+			continue
+		}
+		var cached string
+		if !DisableCache {
+			cached = cachedUserDir(username)
+		}
+		if cached != "" {
+			result[username] = cached
+		} else {
+			missing = append(missing, username)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	out, err := run(nil, "getent", append([]string{"passwd"}, missing...)...)
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		// A non-zero exit status only means that some keys are unknown;
+		// getent still prints the entries it found
+		return nil, err
+	}
+
+	var entries []*Passwd
+	for _, line := range strings.Split(outputString(out), "\n") {
+		entry, err := ParsePasswdLine(strings.TrimSpace(line))
+		if err != nil || entry.Dir == "" || checkRestricted(entry) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	for _, entry := range entries {
+		result[entry.Name] = entry.Dir
+		cacheUserDirLocked(entry.Name, entry.Dir)
+	}
+
+	return result, nil
+}
+
+func userUnix() (string, string, error) {
+	for _, method := range userMethodOrder {
+		var user string
+		source := method
+		switch method {
+		case "env":
+			// Prefer the USER environmental variable
+			user = os.Getenv("USER")
+			source = "env:USER"
+		case "whoami":
+			out, err := run(nil, "whoami")
+			if err != nil {
+				// If "whoami" is missing, ignore it
+				if err == exec.ErrNotFound {
+					return "", "", err
+				}
+			} else if !whoamiBypass {
+				user = outputString(out)
+			}
+		case "id":
+			// Run id in the C locale so the output format is predictable
+			out, err := run(cLocaleEnv(), "id")
+			if err != nil {
+				// If "id" is missing, ignore it
+				if err == exec.ErrNotFound {
+					return "", "", err
+				}
+			}
+			user, _ = parseIDUser(outputString(out))
+		case "getent":
+			out, err := run(nil, "getent", "passwd", strconv.Itoa(lookupUID()))
+			if err == nil {
+				user, _ = splitPasswd(outputString(out))
+			}
+		}
+
+		if user != "" {
+			return user, source, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("exhausted methods to obtain username")
+}
+
+// SetUserMethodOrder sets the methods User() tries on Unix systems, in
+// order. Valid methods are
+//
+//	env     the USER environment variable
+//	whoami  the output of whoami
+//	id      the output of id
+//	getent  the passwd entry of the current uid, see SetUseEffectiveUID
+//
+// The default order is env, whoami, id. Methods left out are not tried, so
+// for example []string{"id"} trusts only id. Passing an empty list restores
+// the default. The cached user name is cleared.
+func SetUserMethodOrder(methods []string) error {
+	for _, method := range methods {
+		switch method {
+		case "env", "whoami", "id", "getent":
+		default:
+			return fmt.Errorf("unknown user discovery method %q", method)
+		}
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if len(methods) == 0 {
+		userMethodOrder = defaultUserMethodOrder
+	} else {
+		userMethodOrder = append([]string(nil), methods...)
+	}
+	userCache = ""
+	userSource = ""
+	return nil
+}
+
+// parseIDUser returns the user name from the output of id.
+func parseIDUser(out string) (string, bool) {
+	sm := idUserRe.FindStringSubmatch(out)
+	if len(sm) != 2 {
+		return "", false
+	}
+
+	return sm[1], true
+}
+
+// outputString returns the output of a command with carriage returns
+// removed and surrounding white space trimmed, so that output with CRLF line
+// endings, as emitted by some Cygwin-wrapped binaries, parses like LF output.
+func outputString(out []byte) string {
+	return strings.TrimSpace(strings.ReplaceAll(string(out), "\r", ""))
+}
+
+// cLocaleEnv returns the environment of the current process with LC_ALL set
+// to C.
+func cLocaleEnv() []string {
+	return append(os.Environ(), "LC_ALL=C")
+}
+
+func userWindows() (string, string, error) {
+	// First prefer the USER environmental variable
+	if user := os.Getenv("USERNAME"); user != "" {
+		return user, "env:USERNAME", nil
+	}
+
+	return "", "", fmt.Errorf("exhausted methods to obtain username")
+}
+
+// Expand expands the path to include the home directory if the path
+// is prefixed with `~`. If it isn't prefixed with `~`, the path is
+// returned as-is.
+//
+// The expanded path is cleaned with filepath.Join, so `.` and `..` elements
+// are resolved relative to the home directory: with a home directory of
+// /home/bob, "~/." expands to /home/bob, "~/.." to /home and "~/../x" to
+// /home/x. Duplicate separators are collapsed as well, so "~//foo///bar"
+// expands to /home/bob/foo/bar. Paths returned as-is are not cleaned and
+// keep any duplicate separators; use ExpandClean to normalize those too.
+//
+// The `~` must be followed by a path separator or nothing; `~user` is an
+// error. On Windows both "~/foo" and "~\foo" are accepted, while "~C:foo"
+// reports that a drive cannot follow the tilde and "~\\server\share" that a
+// UNC path cannot.
+//
+// Expand is idempotent for its own output: expanding an expanded path again
+// returns it unchanged, since it no longer starts with `~`. The same holds
+// for ExpandClean and ExpandForUser.
+func Expand(path string) (string, error) {
+	result, _, err := ExpandReport(path)
+	return result, err
+}
+
+// ExpandTagged removes a leading tag, such as "path:" in "path:~/x", from
+// value and expands the remainder like Expand. Values without the tag are
+// returned as-is, or rejected with an error if RequireTag is set. An empty
+// tag matches every value.
+func ExpandTagged(value, tag string) (string, error) {
+	if !strings.HasPrefix(value, tag) {
+		if RequireTag {
+			return "", fmt.Errorf("cannot expand %q: missing tag %q", value, tag)
+		}
+		return value, nil
+	}
+
+	return Expand(value[len(tag):])
+}
+
+// ExpandClean is like Expand but also applies filepath.Clean to paths
+// without a `~` prefix, so "/a//b" becomes /a/b. The empty path is returned
+// as-is rather than as ".".
+func ExpandClean(path string) (string, error) {
+	result, err := Expand(path)
+	if err != nil || result == "" {
+		return result, err
+	}
+
+	return filepath.Clean(result), nil
+}
+
+// ExpandOrRel is like Expand but cleans relative paths without a `~` prefix
+// with filepath.Clean, so "./foo//bar" becomes "foo/bar", while keeping them
+// relative: they are joined neither to the home directory nor to the working
+// directory. Absolute paths are returned as-is, unlike with ExpandClean, and
+// the empty path stays empty.
+func ExpandOrRel(path string) (string, error) {
+	result, err := Expand(path)
+	if err != nil || result == "" || filepath.IsAbs(result) {
+		return result, err
+	}
+
+	return filepath.Clean(result), nil
+}
+
+// ExpandHomeRelative is like Expand but interprets relative paths relative
+// to the home directory rather than the working directory. The three cases
+// are
+//
+//	~/Documents/notes   expanded like Expand
+//	/etc/app.conf       returned as-is
+//	Documents/notes     joined onto the home directory, as if it were
+//	                    ~/Documents/notes
+//
+// On Windows a path with a volume name, such as "C:notes", or with a leading
+// separator is returned as-is as well, since it isn't relative to any
+// directory in particular. The empty path stays empty.
+func ExpandHomeRelative(path string) (string, error) {
+	if path == "" || strings.HasPrefix(path, "~") || filepath.IsAbs(path) ||
+		filepath.VolumeName(path) != "" || os.IsPathSeparator(path[0]) {
+		return Expand(path)
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return postProcess(filepath.Join(dir, path)), nil
+}
+
+// ExpandWithConfidence is like Expand but also reports whether the home
+// directory used is trustworthy. highConfidence is only true when DirSource
+// reports env:HOME or env:USERPROFILE, the variables the user controls
+// directly, and for paths returned as-is. Every other source, including the
+// other variables of the environment chain, os.UserHomeDir, OverrideEnv and
+// DefaultDir, is reported as low confidence so that callers can warn that
+// the home directory may not be the one the user expects.
+func ExpandWithConfidence(path string) (result string, highConfidence bool, err error) {
+	if ok, err := hasTilde(path); err != nil {
+		return "", false, err
+	} else if !ok {
+		return path, true, nil
+	}
+
+	dir, source, err := DirSource()
+	if err != nil {
+		return "", false, err
+	}
+
+	highConfidence = source == "env:HOME" || source == "env:USERPROFILE"
+	return postProcess(joinHome(dir, path[1:])), highConfidence, nil
+}
+
+// ExpandOrKeep is like Expand but returns path unchanged instead of an
+// error, for example when the home directory cannot be determined or path
+// uses the `~user` form. Callers must therefore be prepared to handle paths
+// that still start with `~`.
+func ExpandOrKeep(path string) string {
+	result, err := Expand(path)
+	if err != nil {
+		return path
+	}
+	return result
+}
+
+// ExpandReport is like Expand but also reports whether a `~` prefix was
+// actually resolved. expanded is false when the path was returned as-is.
+func ExpandReport(path string) (result string, expanded bool, err error) {
+	// Paths without a tilde prefix must return before the cache lock or
+	// Dir() are touched; BenchmarkExpandAbsolute depends on it.
+	if ok, err := hasTilde(path); err != nil {
+		return "", false, err
+	} else if !ok {
+		return path, false, nil
+	}
+
+	useCache := !DisableCache && !homePinned()
+	if useCache {
+		cacheLock.RLock()
+		cached, ok := expandCache[path]
+		fresh := cacheFresh(homedirCachedAt)
+		recent := lruRecent(expandCacheOrder, path)
+		cacheLock.RUnlock()
+		if ok && fresh {
+			if !recent {
+				cacheLock.Lock()
+				if _, ok := expandCache[path]; ok {
+					lruTouch(expandCacheOrder, path)
+				}
+				cacheLock.Unlock()
+			}
+			return postProcess(cached), true, nil
+		}
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", false, err
+	}
+
+	result = joinHome(dir, path[1:])
+	if useCache {
+		cacheExpanded(path, result)
+	}
+	return postProcess(result), true, nil
+}
+
+// joinHome returns filepath.Join(dir, rest). When dir is a clean absolute
+// path and rest is a separator followed by plain names, as in "/foo/bar",
+// the result is built directly, which allocates once instead of three times
+// in Join.
+func joinHome(dir, rest string) string {
+	if !simpleSuffix(rest) || !filepath.IsAbs(dir) || filepath.Clean(dir) != dir {
+		return filepath.Join(dir, rest)
+	}
+
+	var b strings.Builder
+	b.Grow(len(dir) + len(rest))
+	b.WriteString(dir)
+	if os.IsPathSeparator(dir[len(dir)-1]) {
+		rest = rest[1:]
+	}
+	for i := 0; i < len(rest); i++ {
+		if os.IsPathSeparator(rest[i]) {
+			b.WriteByte(filepath.Separator)
+		} else {
+			b.WriteByte(rest[i])
+		}
+	}
+	return b.String()
+}
+
+// simpleSuffix reports whether rest is a separator followed by one or more
+// names separated by single separators, none of which is "." or "..", so
+// that joining it to a clean directory needs no cleaning.
+func simpleSuffix(rest string) bool {
+	if len(rest) < 2 || !os.IsPathSeparator(rest[0]) {
+		return false
+	}
+
+	start := 1
+	for i := 1; i <= len(rest); i++ {
+		if i < len(rest) && !os.IsPathSeparator(rest[i]) {
+			continue
+		}
+		switch rest[start:i] {
+		case "", ".", "..":
+			return false
+		}
+		start = i + 1
+	}
+	return true
+}
+
+// SetExpandPostProcessor sets a function that is applied to every path
+// expanded by Expand and the functions built on it, including
+// ExpandPathList and ExpandPathListString, as well as by ExpandUser,
+// ExpandFrom, ExpandForUser and ExpandWithMap. It runs after the home directory
+// has been joined and cleaned, and only for paths that actually had a `~`
+// prefix; other paths are still returned as-is. This can be used to map
+// /home to /exported/home on a particular cluster, for example. Passing nil
+// restores the default of leaving expanded paths unchanged.
+func SetExpandPostProcessor(f func(string) string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	expandPostProcessor = f
+}
+
+// postProcess applies the function set by SetExpandPostProcessor to an
+// expanded path.
+func postProcess(path string) string {
+	cacheLock.RLock()
+	f := expandPostProcessor
+	cacheLock.RUnlock()
+	if f == nil {
+		return path
+	}
+	return f(path)
+}
+
+// ExpandFrom is like Expand but uses home as the home directory instead of
+// discovering it.
+func ExpandFrom(home, path string) (string, error) {
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if !ok {
+		return path, nil
+	}
+
+	return postProcess(filepath.Join(home, path[1:])), nil
+}
+
+// Expander expands paths against a home directory resolved once by the
+// caller, for example per request in a server handling several users.
+type Expander struct {
+	Home string
+}
+
+// Expand is like the package-level Expand but uses e.Home, see ExpandFrom.
+func (e Expander) Expand(path string) (string, error) {
+	return ExpandFrom(e.Home, path)
+}
+
+// ExpandForUser is like Expand but a leading `~` stands for the home
+// directory of the named user, as returned by DirFor, instead of that of the
+// current user. An error is returned if the user is unknown.
+func ExpandForUser(path, username string) (string, error) {
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if !ok {
+		return path, nil
+	}
+
+	dir, err := DirFor(username)
+	if err != nil {
+		return "", err
+	}
+
+	return ExpandFrom(dir, path)
+}
+
+// ExpandUser is like Expand but also accepts the `~user` form, as in
+// "~alice/notes", which is resolved to the home directory of the named user
+// with DirFor. On Windows, which has no passwd database, only
+// "~Administrator" is supported and resolves to the profile of the built-in
+// Administrator account from the profile list.
+//
+// "~root" resolves to whatever the passwd database records for root, which
+// is /root on most distributions but "/" in many minimal containers; in the
+// latter case "~root/x" becomes "/x". RejectRootHome does not apply here.
+func ExpandUser(path string) (string, error) {
+	result, _, _, err := ExpandUserReport(path)
+	return result, err
+}
+
+// ExpandUserReport is like ExpandUser but also reports whose home directory
+// the `~` was resolved to, for auditing: username is the named user for
+// "~bob/x" and empty for "~/x", and home is the home directory used. Both
+// are empty for paths returned as-is.
+func ExpandUserReport(path string) (result, username, home string, err error) {
+	if len(path) < 2 || path[0] != '~' || path[1] == '/' || path[1] == '\\' ||
+		(runtime.GOOS == "windows" && filepath.VolumeName(path[1:]) != "") {
+		if ok, err := hasTilde(path); err != nil {
+			return "", "", "", err
+		} else if !ok {
+			return path, "", "", nil
+		}
+		if home, err = Dir(); err != nil {
+			return "", "", "", err
+		}
+		return postProcess(joinHome(home, path[1:])), "", home, nil
+	}
+
+	name, rest := path[1:], ""
+	if i := strings.IndexAny(name, `/\`); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+
+	if runtime.GOOS == "windows" {
+		home, err = profileDir(name)
+	} else {
+		home, err = DirFor(name)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("cannot expand %q: %w", path, err)
+	}
+
+	return postProcess(joinHome(home, rest)), name, home, nil
+}
+
+// ExpandLenient is like ExpandUser but returns `~name` paths it cannot
+// resolve unchanged instead of reporting an error, so that "~later/x" can be
+// left for a later stage of a templating pipeline. Expand, in contrast,
+// rejects every `~name`, and ExpandStrict additionally rejects tildes that
+// Expand would leave alone. Only unknown users and invalid user names, see
+// ErrUnknownUser and ErrInvalidUsername, are let through: accounts refused by
+// the policy set with SetMinUID, malformed paths such as "~C:foo" on Windows
+// and a failure to determine the home directory for `~` itself are still
+// reported as errors.
+func ExpandLenient(path string) (string, error) {
+	result, _, _, err := ExpandUserReport(path)
+	if err != nil && isUserTilde(path) && (errors.Is(err, ErrUnknownUser) || errors.Is(err, ErrInvalidUsername)) {
+		return path, nil
+	}
+	return result, err
+}
+
+// isUserTilde reports whether path has the `~name` form.
+func isUserTilde(path string) bool {
+	return len(path) > 1 && path[0] == '~' && path[1] != '/' && path[1] != '\\'
+}
+
+// ExpandWithMap is like Expand but supports named roots: a leading
+// `~name`, as in "~cfg/app.toml", is replaced by roots["name"]. A bare `~`
+// uses roots[""] if present and the home directory otherwise. An error is
+// returned for names missing from roots.
+func ExpandWithMap(path string, roots map[string]string) (string, error) {
+	if len(path) == 0 || path[0] != '~' {
+		return path, nil
+	}
+
+	name, rest := path[1:], ""
+	if i := strings.IndexAny(name, `/\`); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+
+	root, ok := roots[name]
+	if !ok {
+		if name != "" {
+			return "", fmt.Errorf("unknown root ~%s in %q", name, path)
+		}
+		return Expand(path)
+	}
+
+	return postProcess(filepath.Join(root, rest)), nil
+}
+
+// ExpandStrict is like Expand but also rejects any `~` that Expand would
+// leave in place, such as in "/a/~b", "foo~" or "~/x~". This is stricter than
+// POSIX shells, which treat such tildes as ordinary characters, and is meant
+// for validating paths where a stray tilde is most likely a mistake.
+func ExpandStrict(path string) (string, error) {
+	rest := path
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if ok {
+		rest = path[1:]
+	}
+
+	if strings.Contains(rest, "~") {
+		return "", fmt.Errorf("unexpandable tilde in %q", path)
+	}
+
+	return Expand(path)
+}
+
+// ExpandSafe is like Expand but returns an error if the cleaned result is a
+// filesystem root such as "/" or a volume root such as `C:\` or
+// `\\server\share`. It guards destructive operations against a home
+// directory that is "/" or inputs such as "~/../.." that would otherwise
+// target the entire disk. Paths without a `~` prefix are checked too.
+func ExpandSafe(path string) (string, error) {
+	result, err := Expand(path)
+	if err != nil {
+		return "", err
+	}
+
+	cleaned := filepath.Clean(result)
+	if rest := cleaned[len(filepath.VolumeName(cleaned)):]; rest == "" || (len(rest) == 1 && os.IsPathSeparator(rest[0])) {
+		return "", fmt.Errorf("refusing to expand %q to the filesystem root %q", path, cleaned)
+	}
+
+	return result, nil
+}
+
+// SamePath reports whether a and b refer to the same location, so that
+// "~/x", "/home/bob/x" and "$HOME/x" compare equal. Both are expanded with
+// ExpandExceptTilde and Expand, made absolute and cleaned, and then
+// resolved with filepath.EvalSymlinks where possible; a path that cannot be
+// resolved, for example because it doesn't exist, is compared unresolved.
+// On Windows and macOS the comparison is case insensitive.
+func SamePath(a, b string) (bool, error) {
+	resolvedA, err := resolvePath(a)
+	if err != nil {
+		return false, err
+	}
+	resolvedB, err := resolvePath(b)
+	if err != nil {
+		return false, err
+	}
+
+	if caseInsensitiveFS() {
+		return strings.EqualFold(resolvedA, resolvedB), nil
+	}
+	return resolvedA == resolvedB, nil
+}
+
+// resolvePath returns the absolute, best-effort resolved form of path for
+// SamePath.
+func resolvePath(path string) (string, error) {
+	result, err := ExpandExceptTilde(path)
+	if err != nil {
+		return "", err
+	}
+	if result, err = Expand(result); err != nil {
+		return "", err
+	}
+
+	return canonicalPath(result)
+}
+
+// canonicalPath returns path made absolute and, where possible, with
+// symbolic links resolved.
+func canonicalPath(path string) (string, error) {
+	result, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(result); err == nil {
+		return resolved, nil
+	}
+	return result, nil
+}
+
+// ExpandUnique expands each of paths with ExpandEnv, cleans it and drops
+// those that refer to a location seen before, as compared by SamePath, so
+// that "~/logs", "$HOME/logs" and "/home/bob/logs" yield a single
+// "/home/bob/logs". The first form of each location is kept, in the order
+// of paths. An error is returned if any path cannot be expanded.
+func ExpandUnique(paths []string) ([]string, error) {
+	fold := caseInsensitiveFS()
+	seen := make(map[string]bool, len(paths))
+	result := make([]string, 0, len(paths))
+	for i, path := range paths {
+		expanded, err := ExpandEnv(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand element %d %q: %v", i, path, err)
+		}
+		expanded = filepath.Clean(expanded)
+
+		key, err := canonicalPath(expanded)
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand element %d %q: %v", i, path, err)
+		}
+		if fold {
+			key = strings.ToLower(key)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, expanded)
+	}
+
+	return result, nil
+}
+
+// ExpandKeepSuffix expands the part of path before the first occurrence of
+// any character in suffixChars and re-appends the rest unchanged, so that
+// "~/docs/report.pdf#page=3" keeps its "#page=3" fragment. If suffixChars is
+// empty it defaults to "#?".
+func ExpandKeepSuffix(path string, suffixChars string) (string, error) {
+	if suffixChars == "" {
+		suffixChars = "#?"
+	}
+
+	suffix := ""
+	if i := strings.IndexAny(path, suffixChars); i >= 0 {
+		path, suffix = path[:i], path[i:]
+	}
+
+	result, err := Expand(path)
+	if err != nil {
+		return "", err
+	}
+
+	return result + suffix, nil
+}
+
+// ExpandPathList splits list on os.PathListSeparator, like PATH, and
+// expands each element. Empty elements are skipped. The home directory is
+// resolved at most once. An error names the element that could not be
+// expanded.
+func ExpandPathList(list string) ([]string, error) {
+	return expandList(filepath.SplitList(list), true)
+}
+
+// ExpandPathListString is like ExpandPathList but returns the expanded
+// elements joined by os.PathListSeparator again, such as "~/go:~/work/go"
+// to "/home/bob/go:/home/bob/work/go", which is handy for rewriting an
+// environment variable before passing it to a child process. Empty elements
+// are kept, since they are meaningful in lists like PATH.
+func ExpandPathListString(list string) (string, error) {
+	if list == "" {
+		return "", nil
+	}
+
+	elems, err := expandList(filepath.SplitList(list), false)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(elems, string(os.PathListSeparator)), nil
+}
+
+// ExpandCSV splits value on commas, as in "~/a, ~/b,/etc/c", trims the
+// white space around each element and expands it. Empty elements are
+// skipped. The home directory is resolved at most once.
+func ExpandCSV(value string) ([]string, error) {
+	elems := strings.Split(value, ",")
+	for i, elem := range elems {
+		elems[i] = strings.TrimSpace(elem)
+	}
+
+	return expandList(elems, true)
+}
+
+// ExpandSliceInPlace expands each element of paths in place, which suits
+// flag values gathered into a []string, such as a pflag StringSlice. The
+// home directory is resolved at most once. On error paths is left unchanged
+// and the error names the index of the offending element.
+func ExpandSliceInPlace(paths []string) error {
+	var dir string
+	expanded := make([]string, len(paths))
+	for i, path := range paths {
+		ok, err := hasTilde(path)
+		if err == nil && ok && dir == "" {
+			dir, err = Dir()
+		}
+		if err != nil {
+			return fmt.Errorf("cannot expand element %d %q: %v", i, path, err)
+		}
+		if ok {
+			path = postProcess(filepath.Join(dir, path[1:]))
+		}
+		expanded[i] = path
+	}
+
+	copy(paths, expanded)
+	return nil
+}
+
+// expandList expands each element of elems, resolving the home directory at
+// most once. Empty elements are dropped if skipEmpty is set.
+func expandList(elems []string, skipEmpty bool) ([]string, error) {
+	var dir string
+	var result []string
+	for _, elem := range elems {
+		if elem == "" && skipEmpty {
+			continue
+		}
+
+		ok, err := hasTilde(elem)
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand %q: %v", elem, err)
+		}
+		if ok {
+			if dir == "" {
+				if dir, err = Dir(); err != nil {
+					return nil, fmt.Errorf("cannot expand %q: %v", elem, err)
+				}
+			}
+			elem = postProcess(filepath.Join(dir, elem[1:]))
+		}
+		result = append(result, elem)
+	}
+
+	return result, nil
+}
+
+// hasTilde reports whether path starts with a `~` prefix that Expand
+// replaces with the home directory. An error is returned for the
+// user-specific form `~user`. On Windows a drive letter right after the
+// tilde, as in the drive-relative "~C:foo" or the rooted "~C:\foo", is
+// reported as such since it is most likely a mistake rather than a user
+// name. Likewise a UNC path such as "~\\server\share" cannot be placed
+// beneath the home directory and is an error on Windows.
+func hasTilde(path string) (bool, error) {
+	if len(path) == 0 || path[0] != '~' {
+		return false, nil
+	}
+
+	if len(path) > 1 && path[1] != '/' && path[1] != '\\' {
+		if runtime.GOOS == "windows" && filepath.VolumeName(path[1:]) != "" {
+			return false, fmt.Errorf("cannot expand %q: `~` must be followed by a path separator, not a drive", path)
+		}
+		return false, errors.New("cannot expand user-specific home dir")
+	}
+
+	if runtime.GOOS == "windows" {
+		if vol := filepath.VolumeName(path[1:]); len(vol) > 2 && strings.ContainsAny(vol[2:], `\/`) {
+			return false, fmt.Errorf("cannot expand %q: `~` cannot be followed by the UNC path %s", path, vol)
+		}
+	}
+
+	return true, nil
+}
+
+// cacheExpanded remembers the expansion of path, evicting the least
+// recently used entry once maxCacheEntries entries are cached.
+func cacheExpanded(path, result string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if maxCacheEntries <= 0 {
+		return
+	}
+	if _, ok := expandCache[path]; ok {
+		lruTouch(expandCacheOrder, path)
+		return
+	}
+	for len(expandCacheOrder) >= maxCacheEntries {
+		delete(expandCache, expandCacheOrder[0])
+		expandCacheOrder = expandCacheOrder[1:]
+	}
+	expandCache[path] = result
+	expandCacheOrder = append(expandCacheOrder, path)
+}
+
+func dirUnix() (string, string, error) {
+	if discoveryStrategy == "passwd-first" {
+		if home, source, err := passwdHome(); err != nil || home != "" {
+			return home, source, err
+		}
+	}
+
+	// First prefer the HOME environmental variable
+	if home, key := dirFromEnv(); home != "" {
+		checkDsclHome(home)
+		return home, "env:" + key, nil
+	}
+	if discoveryStrategy == "env-only" {
+		return "", "", fmt.Errorf("%w: %s are blank or not absolute", ErrNoHomeDir, strings.Join(dirEnvChain, ", "))
+	}
+
+	// On Linux a systemd-activated user service may only find HOME in the
+	// systemd user manager's environment
+	if home := systemdHome(); home != "" {
+		if logger != nil {
+			logger("systemd user manager has HOME", "home", home)
+		}
+		return filepath.Clean(home), "systemd", nil
+	}
+
+	if discoveryStrategy != "passwd-first" {
+		if home, source, err := passwdHome(); err != nil || home != "" {
+			return home, source, err
+		}
+	}
+
+	// If all else fails, try the shell
+	shell := fallbackShell()
+	if logger != nil {
+		logger("falling back to shell", "shell", shell)
+	}
+	if shell == "" {
+		return "", "", errNoShell
+	}
+	out, err := run(shellEnv(), shell, "-c", "cd && pwd")
+	if err != nil {
+		if logger != nil {
+			logger("shell failed", "err", err)
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", "", errNoShell
+		}
+		return "", "", err
+	}
+
+	result := outputString(out)
+	if logger != nil {
+		logger("shell returned", "home", result)
+	}
+	if result == "" {
+		return "", "", errors.New("blank output when reading home directory")
+	}
+
+	// With HOME unset many shells treat a bare cd as a no-op, so pwd just
+	// reports the current working directory
+	if cwd, err := os.Getwd(); err == nil && result == cwd {
+		return "", "", ErrNoHomeDir
+	}
+
+	return result, "shell", nil
+}
+
+// SetShellFallbackEnv sets the environment of the shell run by the final
+// discovery method, `sh -c "cd && pwd"`. By default the shell only gets
+// PATH and LC_ALL=C rather than the full environment, so that variables
+// like BASH_ENV, ENV or PROMPT_COMMAND can't make it print anything extra.
+// Passing nil restores the default; an empty, non-nil slice runs the shell
+// with no environment at all. The cached home directory is cleared.
+func SetShellFallbackEnv(env []string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if env != nil {
+		env = append([]string{}, env...)
+	}
+	shellFallbackEnv = env
+	clearDirCacheLocked()
+}
+
+// shellEnv returns the environment of the shell fallback, see
+// SetShellFallbackEnv.
+func shellEnv() []string {
+	if shellFallbackEnv != nil {
+		return shellFallbackEnv
+	}
+	return []string{"PATH=" + os.Getenv("PATH"), "LC_ALL=C"}
+}
+
+// SetLogger sets a function that is called at each step of home directory
+// discovery, such as "trying getent" or "falling back to shell", with
+// alternating keys and values describing the step, so that resolution can be
+// traced in production. It is easily adapted to log/slog and similar
+// libraries. The function is called with the package lock held and must not
+// call back into this package. Passing nil, the default, disables logging.
+func SetLogger(f func(msg string, keyvals ...interface{})) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	logger = f
+}
+
+// passwdHome returns the home directory from the passwd database, using
+// getent and on some BSDs pw. home is "" if neither has a usable entry.
+func passwdHome() (home, source string, err error) {
+	uid := strconv.Itoa(lookupUID())
+	if logger != nil {
+		logger("trying getent", "uid", uid)
+	}
+	out, err := run(nil, "getent", "passwd", uid)
+	if err != nil {
+		if logger != nil {
+			logger("getent failed", "err", err)
+		}
+		// If "getent" is missing, ignore it
+		if err == exec.ErrNotFound {
+			return "", "", err
+		}
+	} else {
+		if _, home := splitPasswd(outputString(out)); home != "" {
+			if logger != nil {
+				logger("getent returned", "home", home)
+			}
+			if home != "/" || !RejectRootHome {
+				return home, "getent", nil
+			}
+		}
+	}
+
+	// On FreeBSD and DragonFly the passwd database may be managed with pw,
+	// whose view of the home directory is the authoritative one. OpenBSD
+	// and NetBSD have no pw(8), so there this step is skipped
+	if home := pwHome(); home != "" && (home != "/" || !RejectRootHome) {
+		if logger != nil {
+			logger("pw usershow returned", "home", home)
+		}
+		return filepath.Clean(home), "pw", nil
+	}
+
+	return "", "", nil
+}
+
+// SetPreferStdlib makes Dir() try os.UserHomeDir before any of its own
+// methods, falling back to them only if it fails or returns a relative
+// path. os.UserHomeDir only reads the environment: HOME on Unix, USERPROFILE
+// on Windows and home on Plan 9. It never consults systemd, the passwd
+// database or the shell, and ignores SetDirEnvChain and
+// SetDiscoveryStrategy, so with a stale HOME it is fast but may be wrong.
+//
+// OverrideEnv, DefaultDir and the homedir_test build tag still take
+// precedence, and a home set with SetUnusableHomes is still refused. The
+// default of false keeps the discovery described at DirSource. The cached
+// home directory is cleared.
+func SetPreferStdlib(prefer bool) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	preferStdlib = prefer
+	clearDirCacheLocked()
+}
+
+// SetDiscoveryStrategy selects the overall precedence of the methods Dir()
+// uses on Unix systems. Valid strategies are
+//
+//	env-first     the environment, see SetDirEnvChain, then systemd, then
+//	              the passwd database, then the shell (the default)
+//	passwd-first  the passwd database, then the environment, systemd and
+//	              the shell; useful in containers whose passwd is right
+//	              while HOME may be stale
+//	env-only      the environment only, for containers whose passwd is
+//	              wrong; no command is ever run
+//
+// Passing "" restores the default. On Windows only the environment is
+// consulted regardless. The cached home directory is cleared.
+func SetDiscoveryStrategy(strategy string) error {
+	switch strategy {
+	case "":
+		strategy = "env-first"
+	case "env-first", "passwd-first", "env-only":
+	default:
+		return fmt.Errorf("unknown discovery strategy %q", strategy)
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	discoveryStrategy = strategy
+	clearDirCacheLocked()
+	return nil
+}
+
+// errNoShell is returned by Dir() when the only remaining discovery method
+// is the shell but there is none, as in distroless containers.
+var errNoShell = fmt.Errorf("%w: no HOME set and no shell available to determine it", ErrNoHomeDir)
+
+// fallbackShell returns the shell used by the final discovery method: sh if
+// /bin/sh exists, otherwise $SHELL if it exists, otherwise "".
+func fallbackShell() string {
+	if _, err := os.Stat("/bin/sh"); err == nil {
+		return "sh"
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		if _, err := os.Stat(shell); err == nil {
+			return shell
+		}
+	}
+	return ""
+}
+
+// SetUseEffectiveUID selects whether Dir() looks up the passwd entry of the
+// effective uid instead of the real uid, which differ in setuid programs.
+// The default is the real uid.
+//
+// Using the real uid resolves the home of the user who ran the program, which
+// is the safe choice for files that user should control. Using the effective
+// uid resolves the home of the account the program runs as; a setuid program
+// that does this must not let the invoking user choose paths beneath it, as
+// those files belong to the privileged account. Either way HOME is consulted
+// first and comes from the caller's environment, so setuid programs should
+// not trust it. The cached home directory is cleared.
+func SetUseEffectiveUID(effective bool) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	useEffectiveUID = effective
+	clearDirCacheLocked()
+}
+
+// lookupUID returns the uid whose passwd entry Dir() uses. It must not be
+// used on Windows, where os.Getuid and os.Geteuid return -1.
+func lookupUID() int {
+	if useEffectiveUID {
+		return os.Geteuid()
+	}
+	return os.Getuid()
+}
+
+// systemdHome returns HOME from the systemd user manager environment, or ""
+// if it cannot be determined. It is only consulted on Linux when
+// XDG_RUNTIME_DIR is set, which indicates a systemd user session.
+func systemdHome() string {
+	if runtime.GOOS != "linux" || os.Getenv("XDG_RUNTIME_DIR") == "" {
+		return ""
+	}
+
+	out, err := run(nil, "systemctl", "--user", "show-environment")
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(outputString(out), "\n") {
+		if strings.HasPrefix(line, "HOME=") {
+			// Like the environment, only an absolute HOME is usable.
+			if home := strings.TrimSpace(line[len("HOME="):]); filepath.IsAbs(home) {
+				return home
+			}
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func dirWindows() (string, string, error) {
+	if home, key := dirFromEnv(); home != "" {
+		return home, "env:" + key, nil
+	}
+
+	return "", "", fmt.Errorf("%s are blank or not absolute", strings.Join(dirEnvChain, ", "))
+}
+
+// SetDirEnvChain sets the ordered list of environment variables Dir()
+// consults before falling back to any other discovery method. The first
+// variable that is set to an absolute path wins. An entry of the form
+// "A+B" stands for the values of A and B concatenated, and is only used if
+// both are set, as with HOMEDRIVE+HOMEPATH on Windows.
+//
+// The default chain is HOME on Unix and USERPROFILE, HOMEDRIVE+HOMEPATH,
+// HOMESHARE+HOMEPATH, HOME on Windows. Passing an empty list restores the
+// default. The cached home directory is cleared.
+func SetDirEnvChain(vars []string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if len(vars) == 0 {
+		dirEnvChain = defaultDirEnvChain()
+	} else {
+		dirEnvChain = append([]string(nil), vars...)
+	}
+	clearDirCacheLocked()
+}
+
+func defaultDirEnvChain() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"USERPROFILE", "HOMEDRIVE+HOMEPATH", "HOMESHARE+HOMEPATH", "HOME"}
+	}
+	return []string{"HOME"}
+}
+
+// dirFromEnv returns the home directory from the first entry of the
+// environment chain that is set to an absolute path, along with that entry.
+// The returned home directory is cleaned. A value such as HOME=~otheruser is
+// not resolved but skipped like any other relative path, so a literal tilde
+// never ends up in the home directory.
+func dirFromEnv() (home, key string) {
+	for _, key := range dirEnvChain {
+		if logger != nil {
+			logger("trying env", "var", key)
+		}
+		home := envChainValue(key, os.Getenv)
+		if strings.HasPrefix(home, "~") && logger != nil {
+			logger("env has unexpanded tilde, skipping", "var", key, "value", home)
+		}
+		if home != "" && filepath.IsAbs(home) {
+			if logger != nil {
+				logger("env has home", "var", key, "home", home)
+			}
+			// Clean so that a trailing separator doesn't end up in the
+			// cached home directory
+			return filepath.Clean(home), key
+		}
+	}
+
+	return "", ""
+}
+
+// envChainValue returns the value of an entry of the environment chain, see
+// SetDirEnvChain, or "" if any of its variables is unset. Variables are read
+// with getenv.
+func envChainValue(key string, getenv func(string) string) string {
+	value := ""
+	for _, name := range strings.Split(key, "+") {
+		v := getenv(name)
+		if v == "" {
+			return ""
+		}
+		value += v
+	}
+	return value
+}
+
+// SetWindowsHomePreference sets the order in which the home directory
+// sources are consulted on Windows. Valid sources are "USERPROFILE",
+// "HOMEDRIVE+HOMEPATH", "HOMESHARE+HOMEPATH" (both variables must be set)
+// and "HOME". The default order is
+//
+//	USERPROFILE, HOMEDRIVE+HOMEPATH, HOMESHARE+HOMEPATH, HOME
+//
+// since HOME is often set by third-party tools such as Git or Cygwin to a
+// value that doesn't match the user's profile. HOMESHARE is the UNC path of
+// the file server share used for folder redirection in some enterprise
+// setups. Sources left out of order are
+// not consulted. It is SetDirEnvChain restricted to the Windows sources.
+func SetWindowsHomePreference(order []string) error {
+	if len(order) == 0 {
+		return errors.New("empty windows home preference")
+	}
+	for _, source := range order {
+		switch source {
+		case "USERPROFILE", "HOMEDRIVE+HOMEPATH", "HOMESHARE+HOMEPATH", "HOME":
+		default:
+			return fmt.Errorf("unknown windows home source %q", source)
+		}
+	}
+
+	SetDirEnvChain(order)
+	return nil
+}
+
+// DirWithEnv returns the home directory Dir() would find in the environment
+// env, given as KEY=VALUE strings such as those of os.Environ, without
+// changing the environment of the process. Only the environment steps of
+// discovery are performed, in the order of Dir(): TestEnv in builds with the
+// homedir_test tag, OverrideEnv, DefaultDir, the variable os.UserHomeDir
+// reads if SetPreferStdlib is set, and the environment chain, see
+// SetDirEnvChain, all looked up in env. No command is run, so where Dir()
+// would fall back to systemd, the passwd database or the shell, an error
+// wrapping ErrNoHomeDir is returned instead. The cache is not used.
+func DirWithEnv(env []string) (string, error) {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			key := kv[:i]
+			if runtime.GOOS == "windows" {
+				key = strings.ToUpper(key)
+			}
+			vars[key] = kv[i+1:]
+		}
+	}
+	getenv := func(key string) string {
+		if runtime.GOOS == "windows" {
+			key = strings.ToUpper(key)
+		}
+		return vars[key]
+	}
+
+	if hermeticBuild {
+		if dir := getenv(TestEnv); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("%w: %s must be set in builds with the homedir_test tag", ErrNoHomeDir, TestEnv)
+	}
+	if override := getenv(OverrideEnv); override != "" {
+		return override, nil
+	}
+	if DefaultDir != "" {
+		return DefaultDir, nil
+	}
+
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	chain := dirEnvChain
+	if preferStdlib {
+		chain = append([]string{stdlibHomeEnv()}, chain...)
+	}
+	for _, key := range chain {
+		if home := envChainValue(key, getenv); home != "" && filepath.IsAbs(home) {
+			home = filepath.Clean(home)
+			if unusableHome(home) {
+				return "", fmt.Errorf("%w: home directory %q is unusable, see SetUnusableHomes", ErrNoHomeDir, home)
+			}
+			return home, nil
+		}
 	}
 
-	return home, nil
+	return "", fmt.Errorf("%w: %s are blank or not absolute", ErrNoHomeDir, strings.Join(dirEnvChain, ", "))
 }
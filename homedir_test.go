@@ -1,12 +1,22 @@
 package homedir
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func patchEnv(key, value string) func() {
@@ -19,6 +29,17 @@ func patchEnv(key, value string) func() {
 	return deferFunc
 }
 
+// patchHome points the home directory environment variables of every
+// platform at dir.
+func patchHome(dir string) func() {
+	restoreHome := patchEnv("HOME", dir)
+	restoreProfile := patchEnv("USERPROFILE", dir)
+	return func() {
+		restoreProfile()
+		restoreHome()
+	}
+}
+
 func BenchmarkDir(b *testing.B) {
 	// We do this for any "warmups"
 	for i := 0; i < 10; i++ {
@@ -31,6 +52,79 @@ func BenchmarkDir(b *testing.B) {
 	}
 }
 
+func BenchmarkExpand(b *testing.B) {
+	Reset()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Expand("~/assets")
+	}
+}
+
+func BenchmarkExpandNoResultCache(b *testing.B) {
+	defer func(size int) { maxCacheEntries = size }(maxCacheEntries)
+	maxCacheEntries = 0
+	Reset()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Expand("~/assets")
+	}
+}
+
+func BenchmarkDirJoin(b *testing.B) {
+	Reset()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dir, _ := Dir()
+		_ = filepath.Join(dir, "assets", "icon.png")
+	}
+}
+
+func BenchmarkDirBytesAppend(b *testing.B) {
+	Reset()
+	home, _ := DirBytes()
+	buf := make([]byte, 0, len(home)+64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = append(append(buf[:0], home...), filepath.Separator)
+		buf = append(append(append(buf, "assets"...), filepath.Separator), "icon.png"...)
+	}
+}
+
+// BenchmarkExpandAbsolute holds the cache lock for writing throughout, so it
+// would deadlock if Expand took the lock for paths without a tilde prefix.
+func BenchmarkExpandAbsolute(b *testing.B) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Expand("/usr/local/assets")
+	}
+}
+
+func TestJoinHome(t *testing.T) {
+	dirs := []string{"/", "/home/bob", "/home/bob/", "/home//bob", "/home/./bob", "home/bob", "", "."}
+	if runtime.GOOS == "windows" {
+		dirs = append(dirs, `C:\`, `C:\Users\bob`, `C:\Users\bob\`, `C:/Users/bob`, `C:`, `\\server\share`, `\\server\share\bob`)
+	}
+	rests := []string{
+		"", "/", "/a", "/a/b", "/a/b/", "//a", "/a//b", "/./a", "/a/.", "/a/..",
+		"/../a", "/.a", "/a..", "/...", "/a b/c", `\a`, `/a\b`, "a", "/a:b",
+	}
+
+	for _, dir := range dirs {
+		for _, rest := range rests {
+			if actual, expected := joinHome(dir, rest), filepath.Join(dir, rest); actual != expected {
+				t.Fatalf("Input: %#v, %#v\n\nOutput: %#v\n\nExpected: %#v", dir, rest, actual, expected)
+			}
+		}
+	}
+}
+
 func TestUser(t *testing.T) {
 	DisableCache = true
 
@@ -72,6 +166,7 @@ func TestUser(t *testing.T) {
 
 	// force id
 	whoamiBypass = true
+	defer func() { whoamiBypass = false }()
 	user, err = User()
 	if err != nil {
 		t.Fatalf("err id: %s", err)
@@ -153,8 +248,9 @@ func TestExpand(t *testing.T) {
 
 	DisableCache = true
 	defer func() { DisableCache = false }()
-	defer patchEnv("HOME", "/custom/path/")()
-	expected := filepath.Join("/", "custom", "path", "foo/bar")
+	defer patchEnv("HOME", nativePath("/custom/path/"))()
+	defer patchEnv("USERPROFILE", nativePath("/custom/path/"))()
+	expected := filepath.Join(nativePath("/"), "custom", "path", "foo/bar")
 	actual, err := Expand("~/foo/bar")
 
 	if err != nil {
@@ -163,3 +259,3082 @@ func TestExpand(t *testing.T) {
 		t.Errorf("Expected: %v; actual: %v", expected, actual)
 	}
 }
+
+// TestMitchellhCompat checks the behavior that code written against
+// mitchellh/go-homedir relies on.
+func TestMitchellhCompat(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = false
+	defer patchHome(nativePath("/custom/path"))()
+	Reset()
+
+	_, err := Expand("~foo/foo")
+	if err == nil || err.Error() != "cannot expand user-specific home dir" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The home directory is cached until Reset
+	if dir, _ := Dir(); dir != nativePath("/custom/path") {
+		t.Fatalf("expected %v got %v", nativePath("/custom/path"), dir)
+	}
+	patchHome(nativePath("/other/path"))
+	if dir, _ := Dir(); dir != nativePath("/custom/path") {
+		t.Fatalf("expected cached %v got %v", nativePath("/custom/path"), dir)
+	}
+	Reset()
+	if dir, _ := Dir(); dir != nativePath("/other/path") {
+		t.Fatalf("expected %v after Reset got %v", nativePath("/other/path"), dir)
+	}
+
+	// DisableCache takes effect immediately
+	DisableCache = true
+	patchHome(nativePath("/third/path"))
+	if dir, _ := Dir(); dir != nativePath("/third/path") {
+		t.Fatalf("expected %v got %v", nativePath("/third/path"), dir)
+	}
+
+	if runtime.GOOS == "windows" {
+		SetDirEnvChain([]string{"HOME", "USERPROFILE", "HOMEDRIVE+HOMEPATH"})
+		defer patchEnv("USERPROFILE", nativePath("/Users/bob"))()
+		if dir, _ := Dir(); dir != nativePath("/third/path") {
+			t.Fatalf("expected HOME to be preferred, got %v", dir)
+		}
+	}
+}
+
+func patchRun(f func(env []string, name string, arg ...string) ([]byte, error)) func() {
+	bck := run
+	run = f
+	return func() {
+		run = bck
+	}
+}
+
+func getentStub(entries map[string]string) func([]string, string, ...string) ([]byte, error) {
+	return func(env []string, name string, arg ...string) ([]byte, error) {
+		if name != "getent" || len(arg) != 2 || arg[0] != "passwd" {
+			return nil, fmt.Errorf("unexpected command %v %v", name, arg)
+		}
+		entry, ok := entries[arg[1]]
+		if !ok {
+			return nil, errors.New("exit status 2")
+		}
+		return []byte(entry + "\n"), nil
+	}
+}
+
+func TestInvokingUserDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchRun(getentStub(map[string]string{
+		"bob":  "bob:x:1000:1000:Bob:/home/bob:/bin/sh",
+		"dave": "dave:x:1001:1001:Dave:/home/dave:/bin/sh",
+		"1002": "carol:x:1002:1002:Carol:/home/carol:/bin/sh",
+	}))()
+	defer patchEnv("HOME", "/root")()
+	defer patchEnv("SUDO_USER", "")()
+	defer patchEnv("DOAS_USER", "")()
+	defer patchEnv("PKEXEC_UID", "")()
+
+	cases := []struct {
+		SudoUser  string
+		DoasUser  string
+		PkexecUID string
+		Output    string
+		Err       bool
+	}{
+		{"", "", "", "/root", false},
+		{"bob", "", "", "/home/bob", false},
+		{"bob", "dave", "1002", "/home/bob", false},
+		{"", "dave", "1002", "/home/dave", false},
+		{"", "", "1002", "/home/carol", false},
+		{"mallory", "", "", "", true},
+		{"", "", "carol", "", true},
+	}
+
+	for _, tc := range cases {
+		os.Setenv("SUDO_USER", tc.SudoUser)
+		os.Setenv("DOAS_USER", tc.DoasUser)
+		os.Setenv("PKEXEC_UID", tc.PkexecUID)
+
+		actual, err := InvokingUserDir()
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc, actual)
+		}
+	}
+}
+
+func TestExpandReport(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(nativePath("/custom/path"))()
+
+	cases := []struct {
+		Input    string
+		Output   string
+		Expanded bool
+		Err      bool
+	}{
+		{"/foo", "/foo", false, false},
+		{"", "", false, false},
+		{"foo/~", "foo/~", false, false},
+		{"~", filepath.Join(nativePath("/custom/path")), true, false},
+		{"~/foo", filepath.Join(nativePath("/custom/path"), "foo"), true, false},
+		{"~foo/foo", "", false, true},
+	}
+
+	for _, tc := range cases {
+		actual, expanded, err := ExpandReport(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+
+		if expanded != tc.Expanded {
+			t.Fatalf("Input: %#v\n\nExpanded: %v", tc.Input, expanded)
+		}
+	}
+}
+
+func TestDirRootHome(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", "")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "getent" {
+			return []byte("root:x:0:0:root:/:/bin/sh\n"), nil
+		}
+		return nil, errors.New("no shell")
+	})()
+
+	dir, err := Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/" {
+		t.Fatalf("expected / got %v", dir)
+	}
+
+	RejectRootHome = true
+	defer func() { RejectRootHome = false }()
+	dir, err = Dir()
+	if err == nil {
+		t.Fatalf("expected error, got %v", dir)
+	}
+}
+
+func TestDirUnusableHome(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = true
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	uid := strconv.Itoa(os.Getuid())
+	patchRun(getentStub(map[string]string{
+		uid: "svc:x:" + uid + ":65534:svc:/nonexistent:/usr/sbin/nologin",
+	}))
+
+	dir, err := Dir()
+	if !errors.Is(err, ErrNoHomeDir) || !strings.Contains(err.Error(), "/nonexistent") {
+		t.Fatalf("expected unusable home error, got %q, %v", dir, err)
+	}
+
+	SetHomeTemplate("/var/lib/{uid}")
+	dir, err = Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := "/var/lib/" + uid; dir != expected {
+		t.Fatalf("expected %v got %v", expected, dir)
+	}
+
+	SetHomeTemplate("")
+	SetUnusableHomes([]string{})
+	dir, err = Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/nonexistent" {
+		t.Fatalf("expected /nonexistent got %v", dir)
+	}
+
+	SetUnusableHomes([]string{"/srv/"})
+	defer patchEnv("HOMEDIR_TEST_UNSET", "/srv")()
+	if dir, err = Dir(); err == nil {
+		t.Fatalf("expected error, got %v", dir)
+	}
+}
+
+func TestPathValue(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(nativePath("/custom/path"))()
+
+	var p string
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var((*PathValue)(&p), "path", "")
+
+	if err := fs.Parse([]string{"-path", "~/foo"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(nativePath("/custom/path"), "foo"); p != expected {
+		t.Fatalf("expected %v got %v", expected, p)
+	}
+
+	if err := fs.Parse([]string{"-path", "/foo"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if p != "/foo" {
+		t.Fatalf("expected /foo got %v", p)
+	}
+
+	if err := fs.Parse([]string{"-path", "~foo/bar"}); err == nil {
+		t.Fatalf("expected error for %v", "~foo/bar")
+	}
+}
+
+func TestAllHomeDirs(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(nativePath("/home/bob"))()
+	defer patchEnv("HOMES", "")()
+
+	sep := string(os.PathListSeparator)
+	cases := []struct {
+		Homes  string
+		Output []string
+	}{
+		{"", []string{nativePath("/home/bob")}},
+		{nativePath("/home/bob"), []string{nativePath("/home/bob")}},
+		{
+			nativePath("/home/bob/") + sep + nativePath("/mnt/a") + sep + sep + nativePath("/mnt/b") + sep + nativePath("/mnt/a"),
+			[]string{
+				nativePath("/home/bob"),
+				nativePath("/mnt/a"),
+				nativePath("/mnt/b"),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		os.Setenv("HOMES", tc.Homes)
+		actual, err := AllHomeDirs()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Homes, err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.Output) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Homes, actual)
+		}
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no discovery commands on windows")
+	}
+
+	defer patchEnv("HOME", "")()
+	defer patchEnv("USER", "")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		switch name {
+		case "getent":
+			return nil, errors.New("getent failed")
+		case "whoami":
+			return []byte("bob\n"), nil
+		case "id":
+			return []byte("uid=1000(bob) gid=1000(bob)\n"), nil
+		}
+		return []byte("/home/bob\n"), nil
+	})()
+
+	report := Diagnose()
+	for _, expected := range []string{
+		"  HOME=\"\"\n",
+		": error: getent failed\n",
+		"  whoami: bob\n",
+		"  sh -c cd && pwd: /home/bob\n",
+		"  Dir(): /home/bob (shell)\n",
+		"  User(): bob (whoami)\n",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("expected %q in report:\n%s", expected, report)
+		}
+	}
+
+	// The reported choice follows the precedence of Dir()
+	defer Snapshot()()
+	DisableCache = true
+	os.Setenv("HOME", "/nonexistent")
+	SetHomeTemplate("/home/{user}")
+	dir, source, err := DirSource()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := "  Dir(): " + dir + " (" + source + ")\n"; source != "template" || !strings.Contains(Diagnose(), expected) {
+		t.Fatalf("expected %q from the template in report:\n%s", expected, Diagnose())
+	}
+	defer patchEnv(OverrideEnv, "/pinned")()
+	if report := Diagnose(); !strings.Contains(report, "  Dir(): /pinned (env:"+OverrideEnv+")\n") {
+		t.Fatalf("expected the override in report:\n%s", report)
+	}
+
+	// Diagnose reads the configuration under the lock
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			SetDirEnvChain([]string{"HOME"})
+		}
+		close(done)
+	}()
+	Diagnose()
+	<-done
+
+	// The commands run without the lock, so a slow one doesn't block Set*
+	var once sync.Once
+	started, release, reported := make(chan bool), make(chan bool), make(chan bool)
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "whoami" {
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+		}
+		return nil, errors.New("not found")
+	})
+	go func() {
+		Diagnose()
+		close(reported)
+	}()
+	<-started
+	SetDirEnvChain([]string{"HOME"})
+	close(release)
+	<-reported
+}
+
+func TestExpandCache(t *testing.T) {
+	defer Reset()
+	defer Snapshot()()
+	SetMaxCacheEntries(2)
+	defer patchHome(nativePath("/custom/path"))()
+	Reset()
+
+	for _, p := range []string{"~/a", "~/b", "~/a", "~/c"} {
+		if _, err := Expand(p); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if len(expandCache) != 2 {
+		t.Fatalf("expected 2 cached entries, got %v", expandCache)
+	}
+	if _, ok := expandCache["~/b"]; ok {
+		t.Fatalf("expected the least recently used ~/b to be evicted: %v", expandCache)
+	}
+
+	// Cached results survive a HOME change until Reset.
+	patchHome(nativePath("/other/path"))
+	actual, _ := Expand("~/c")
+	if expected := filepath.Join(nativePath("/custom/path"), "c"); actual != expected {
+		t.Fatalf("expected %v got %v", expected, actual)
+	}
+
+	Reset()
+	if len(expandCache) != 0 {
+		t.Fatalf("expected empty cache after Reset: %v", expandCache)
+	}
+	actual, _ = Expand("~/c")
+	if expected := filepath.Join(nativePath("/other/path"), "c"); actual != expected {
+		t.Fatalf("expected %v got %v", expected, actual)
+	}
+}
+
+func TestUserDirCacheEviction(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = false
+	Reset()
+	SetMaxCacheEntries(2)
+	lookups := 0
+	stub := getentStub(map[string]string{
+		"alice": "alice:x:1001:1001::/home/alice:/bin/sh",
+		"bob":   "bob:x:1002:1002::/home/bob:/bin/sh",
+		"carol": "carol:x:1003:1003::/home/carol:/bin/sh",
+	})
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		lookups++
+		return stub(env, name, arg...)
+	})
+
+	for _, name := range []string{"alice", "bob", "alice", "carol"} {
+		if _, err := DirFor(name); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if lookups != 3 {
+		t.Fatalf("expected 3 lookups got %d", lookups)
+	}
+	if len(userDirCache) != 2 || userDirCache["bob"] != "" {
+		t.Fatalf("expected the least recently used bob to be evicted: %v", userDirCache)
+	}
+
+	// Lowering the bound evicts right away
+	SetMaxCacheEntries(1)
+	if len(userDirCache) != 1 || userDirCache["carol"] == "" {
+		t.Fatalf("expected only carol to be cached: %v", userDirCache)
+	}
+
+	SetMaxCacheEntries(0)
+	if len(userDirCache) != 0 {
+		t.Fatalf("expected an empty cache: %v", userDirCache)
+	}
+	DirFor("alice")
+	if len(userDirCache) != 0 {
+		t.Fatalf("expected caching to be off: %v", userDirCache)
+	}
+}
+
+func TestWindowsHomePreference(t *testing.T) {
+	defer SetDirEnvChain(nil)
+	defer patchEnv("HOME", nativePath("/cygwin/home/bob"))()
+	defer patchEnv("USERPROFILE", nativePath("/Users/bob"))()
+	defer patchEnv("HOMEDRIVE", nativePath("/mnt"))()
+	defer patchEnv("HOMEPATH", filepath.FromSlash("/bob"))()
+	defer patchEnv("HOMESHARE", nativePath("/share"))()
+
+	cases := []struct {
+		Order  []string
+		Unset  []string
+		Output string
+		Err    bool
+	}{
+		{nil, nil, nativePath("/Users/bob"), false},
+		{nil, []string{"USERPROFILE"}, nativePath("/mnt/bob"), false},
+		{nil, []string{"USERPROFILE", "HOMEDRIVE"}, nativePath("/share/bob"), false},
+		{nil, []string{"USERPROFILE", "HOMEPATH"}, nativePath("/cygwin/home/bob"), false},
+		{nil, []string{"USERPROFILE", "HOMEPATH", "HOME"}, "", true},
+		{[]string{"HOMESHARE+HOMEPATH", "USERPROFILE"}, nil, nativePath("/share/bob"), false},
+		{[]string{"HOME", "USERPROFILE"}, nil, nativePath("/cygwin/home/bob"), false},
+		{[]string{"HOMEDRIVE+HOMEPATH", "USERPROFILE"}, nil, nativePath("/mnt/bob"), false},
+		{[]string{"HOMEDRIVE+HOMEPATH"}, []string{"HOMEDRIVE"}, "", true},
+	}
+
+	for _, tc := range cases {
+		SetDirEnvChain([]string{"USERPROFILE", "HOMEDRIVE+HOMEPATH", "HOMESHARE+HOMEPATH", "HOME"})
+		if tc.Order != nil {
+			if err := SetWindowsHomePreference(tc.Order); err != nil {
+				t.Fatalf("Input: %#v\n\nErr: %s", tc.Order, err)
+			}
+		}
+
+		var restore []func()
+		for _, key := range tc.Unset {
+			restore = append(restore, patchEnv(key, ""))
+		}
+		actual, _, err := dirWindows()
+		for _, f := range restore {
+			f()
+		}
+
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc, actual)
+		}
+	}
+
+	if err := SetWindowsHomePreference([]string{"HOMESHARE"}); err == nil {
+		t.Fatalf("expected error for unknown source")
+	}
+}
+
+func TestSetDirEnvChain(t *testing.T) {
+	defer SetDirEnvChain(nil)
+	defer patchEnv(OverrideEnv, "")()
+	defer patchEnv("HOME", nativePath("/home/bob"))()
+	defer patchEnv("APP_HOME", nativePath("/srv/app"))()
+	defer patchEnv("REL_HOME", "relative/home")()
+
+	cases := []struct {
+		Chain  []string
+		Output string
+		Source string
+	}{
+		{[]string{"APP_HOME", "HOME"}, nativePath("/srv/app"), "env:APP_HOME"},
+		{[]string{"HOME", "APP_HOME"}, nativePath("/home/bob"), "env:HOME"},
+		{[]string{"UNSET_HOME", "APP_HOME"}, nativePath("/srv/app"), "env:APP_HOME"},
+		{[]string{"REL_HOME", "HOME"}, nativePath("/home/bob"), "env:HOME"},
+	}
+
+	for _, tc := range cases {
+		SetDirEnvChain(tc.Chain)
+		dir, source, err := DirSource()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Chain, err)
+		}
+		if dir != tc.Output || source != tc.Source {
+			t.Fatalf("Input: %#v\n\nOutput: %#v %#v", tc.Chain, dir, source)
+		}
+	}
+
+	SetDirEnvChain(nil)
+	if !reflect.DeepEqual(dirEnvChain, defaultDirEnvChain()) {
+		t.Fatalf("expected default chain got %v", dirEnvChain)
+	}
+}
+
+func TestDirSystemd(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("systemd is only consulted on linux")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "/run/user/1000")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "systemctl" {
+			return []byte("LANG=C\nHOME=/home/svc\nPATH=/usr/bin\n"), nil
+		}
+		return nil, fmt.Errorf("unexpected command %v %v", name, arg)
+	})()
+
+	dir, err := Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/home/svc" {
+		t.Fatalf("expected /home/svc got %v", dir)
+	}
+}
+
+func TestDirTildeHome(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("HOME is not consulted on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", "~root")()
+	defer patchEnv("XDG_RUNTIME_DIR", "/run/user/1000")()
+	uid := strconv.Itoa(os.Getuid())
+	getent := getentStub(map[string]string{
+		uid: "bob:x:1000:1000:Bob:/home/bob:/bin/sh",
+	})
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "systemctl" {
+			return []byte("HOME=~root\n"), nil
+		}
+		return getent(env, name, arg...)
+	})()
+
+	dir, source, err := DirSource()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/home/bob" || source != "getent" {
+		t.Fatalf("expected /home/bob from getent got %v from %v", dir, source)
+	}
+
+	actual, err := Expand("~/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(actual, "~") {
+		t.Fatalf("tilde leaked into %v", actual)
+	}
+}
+
+func TestDirOverride(t *testing.T) {
+	defer Reset()
+	defer patchEnv(OverrideEnv, "")()
+	override := nativePath("/pinned/home")
+
+	// Populate the caches before setting the override.
+	Dir()
+	Expand("~/foo")
+
+	os.Setenv(OverrideEnv, override)
+	dir, err := Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != override {
+		t.Fatalf("expected %v got %v", override, dir)
+	}
+
+	actual, err := Expand("~/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(override, "foo"); actual != expected {
+		t.Fatalf("expected %v got %v", expected, actual)
+	}
+}
+
+func TestExpandPathList(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchEnv("HOME", home)()
+	defer patchEnv("USERPROFILE", home)()
+
+	sep := string(os.PathListSeparator)
+	cases := []struct {
+		Input  string
+		Output []string
+		Err    bool
+	}{
+		{"", nil, false},
+		{"~/a", []string{filepath.Join(home, "a")}, false},
+		{
+			"~/a" + sep + sep + "~/b" + sep + nativePath("/etc/x"),
+			[]string{filepath.Join(home, "a"), filepath.Join(home, "b"), nativePath("/etc/x")},
+			false,
+		},
+		{"~/a" + sep + "~alice/b", nil, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandPathList(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), `"~alice/b"`) {
+			t.Fatalf("Input: %#v\n\nErr does not name the element: %s", tc.Input, err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.Output) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestEnsureDir(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := filepath.Join(t.TempDir(), "new", "home")
+	defer patchEnv("HOME", home)()
+	defer patchEnv("USERPROFILE", home)()
+
+	for i := 0; i < 2; i++ {
+		dir, err := EnsureDir(0700)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if dir != home {
+			t.Fatalf("expected %v got %v", home, dir)
+		}
+		if fi, err := os.Stat(home); err != nil || !fi.IsDir() {
+			t.Fatalf("home directory not created: %v", err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Setenv("HOME", filepath.Join(file, "home"))
+	os.Setenv("USERPROFILE", filepath.Join(file, "home"))
+	if _, err := EnsureDir(0700); err == nil {
+		t.Fatalf("expected error creating home beneath a file")
+	}
+}
+
+func TestExpandHomeRelative(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"~/Documents/notes", filepath.Join(home, "Documents", "notes"), false},
+		{"~", home, false},
+		{nativePath("/etc/app.conf"), nativePath("/etc/app.conf"), false},
+		{"Documents/notes", filepath.Join(home, "Documents", "notes"), false},
+		{"./notes//today", filepath.Join(home, "notes", "today"), false},
+		{".", home, false},
+		{"", "", false},
+		{"~bob/notes", "", true},
+	}
+	if runtime.GOOS == "windows" {
+		cases = append(cases, []struct {
+			Input  string
+			Output string
+			Err    bool
+		}{
+			{`C:notes`, `C:notes`, false},
+			{`\notes`, `\notes`, false},
+		}...)
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandHomeRelative(tc.Input)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandWithConfidence(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	actual, high, err := ExpandWithConfidence("~/x")
+	if err != nil || actual != filepath.Join(home, "x") || !high {
+		t.Fatalf("expected %v with high confidence, got %v %v: %v", filepath.Join(home, "x"), actual, high, err)
+	}
+	if actual, high, err := ExpandWithConfidence("/abs"); err != nil || actual != "/abs" || !high {
+		t.Fatalf("expected /abs with high confidence, got %v %v: %v", actual, high, err)
+	}
+	if _, _, err := ExpandWithConfidence("~bob/x"); err == nil {
+		t.Fatalf("expected error for ~bob/x")
+	}
+
+	// Only HOME and USERPROFILE themselves are high confidence
+	for _, tc := range []struct {
+		Source string
+		Setup  func() func()
+	}{
+		{"override", func() func() { return patchEnv(OverrideEnv, home) }},
+		{"default", func() func() {
+			DefaultDir = home
+			return func() { DefaultDir = "" }
+		}},
+		{"chain", func() func() {
+			SetDirEnvChain([]string{"HOMEDIR_TEST_CHAIN_HOME"})
+			restore := patchEnv("HOMEDIR_TEST_CHAIN_HOME", home)
+			return func() {
+				restore()
+				SetDirEnvChain(nil)
+			}
+		}},
+		{"stdlib", func() func() {
+			SetPreferStdlib(true)
+			stdlibHomeDir = func() (string, error) { return home, nil }
+			return func() { SetPreferStdlib(false) }
+		}},
+	} {
+		restore := tc.Setup()
+		actual, high, err := ExpandWithConfidence("~/x")
+		restore()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Source, err)
+		}
+		if actual != filepath.Join(home, "x") || high {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v", tc.Source, actual, high)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// Without HOME, the getent and shell fallbacks are low confidence
+	os.Setenv("HOME", "")
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	uid := strconv.Itoa(os.Getuid())
+	var getent bool
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		switch {
+		case name == "getent" && getent && len(arg) == 2 && arg[1] == uid:
+			return []byte("bob:x:1000:1000::/home/getent:/bin/sh\n"), nil
+		case name == "sh":
+			return []byte("/home/shell\n"), nil
+		}
+		return nil, errors.New("not found")
+	})
+
+	for _, tc := range []struct {
+		Getent bool
+		Output string
+	}{
+		{true, "/home/getent/x"},
+		{false, "/home/shell/x"},
+	} {
+		getent = tc.Getent
+		actual, high, err := ExpandWithConfidence("~/x")
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+		}
+		if actual != tc.Output || high {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v", tc, actual, high)
+		}
+	}
+
+}
+
+func TestExpandTagged(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input   string
+		Tag     string
+		Require bool
+		Output  string
+		Err     bool
+	}{
+		{"path:~/x", "path:", false, filepath.Join(home, "x"), false},
+		{"path:~/x", "path:", true, filepath.Join(home, "x"), false},
+		{"path:/abs", "path:", true, "/abs", false},
+		{"~/x", "path:", false, "~/x", false},
+		{"~/x", "path:", true, "", true},
+		{"file:~/x", "path:", true, "", true},
+		{"path:~bob/x", "path:", false, "", true},
+		{"~/x", "", true, filepath.Join(home, "x"), false},
+	}
+
+	for _, tc := range cases {
+		RequireTag = tc.Require
+		actual, err := ExpandTagged(tc.Input, tc.Tag)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc, actual)
+		}
+	}
+}
+
+func TestExpandEnsureParent(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	path, err := ExpandEnsureParent("~/a/b/c.txt", 0700)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(home, "a", "b", "c.txt"); path != expected {
+		t.Fatalf("expected %v got %v", expected, path)
+	}
+	if fi, err := os.Stat(filepath.Join(home, "a", "b")); err != nil || !fi.IsDir() {
+		t.Fatalf("parent directory not created: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file should not be created: %v", err)
+	}
+
+	if _, err := ExpandEnsureParent("~bob/c.txt", 0700); err == nil || strings.Contains(err.Error(), "parent directory") {
+		t.Fatalf("expected expansion error got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, "file"), nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := ExpandEnsureParent("~/file/sub/c.txt", 0700); err == nil || !strings.Contains(err.Error(), "cannot create parent directory") {
+		t.Fatalf("expected mkdir error got %v", err)
+	}
+}
+
+func TestParseIDUser(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+		OK     bool
+	}{
+		{"uid=1000(bob) gid=1000(bob) groups=1000(bob)", "bob", true},
+		{"uid=1000(user.name) gid=1000(users)", "user.name", true},
+		{"uid=1000(user-name) gid=1000(users)", "user-name", true},
+		{"uid=1000(jürgen) gid=1000(jürgen)", "jürgen", true},
+		{"uid=1000(bob)\n", "bob", true},
+		{"uid=1000 gid=1000", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range cases {
+		actual, ok := parseIDUser(tc.Input)
+		if ok != tc.OK || actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v %v", tc.Input, actual, ok)
+		}
+	}
+}
+
+func TestUserEquals(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("USER", "Bob")()
+	defer patchEnv("USERNAME", "Bob")()
+
+	fold := runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	cases := []struct {
+		Input  string
+		Output bool
+	}{
+		{"Bob", true},
+		{"bob", fold},
+		{"BOB", fold},
+		{"alice", false},
+		{"", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := UserEquals(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	for _, fold := range []bool{false, true} {
+		if !userEquals("bob", "bob", fold) || userEquals("bob", "alice", fold) {
+			t.Fatalf("fold %v: exact comparison failed", fold)
+		}
+		if userEquals("Bob", "bob", fold) != fold {
+			t.Fatalf("fold %v: case comparison failed", fold)
+		}
+	}
+}
+
+func TestUserIDLocale(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("id is not used on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("USER", "")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name != "id" {
+			return nil, errors.New("not found")
+		}
+		for _, kv := range env {
+			if kv == "LC_ALL=C" {
+				return []byte("uid=1000(user.name) gid=1000(users)\n"), nil
+			}
+		}
+		return nil, errors.New("id not run in the C locale")
+	})()
+
+	user, err := User()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if user != "user.name" {
+		t.Fatalf("expected user.name got %v", user)
+	}
+}
+
+func TestUserSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("whoami and id are not used on windows")
+	}
+
+	defer Snapshot()()
+	defer patchEnv("USER", "")()
+	uid := strconv.Itoa(os.Getuid())
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		switch name {
+		case "whoami":
+			return []byte("whoamiuser\n"), nil
+		case "id":
+			return []byte("uid=1000(iduser) gid=1000(users)\n"), nil
+		case "getent":
+			if len(arg) == 2 && arg[1] == uid {
+				return []byte("getentuser:x:1000:1000::/home/getentuser:/bin/sh\n"), nil
+			}
+		}
+		return nil, fmt.Errorf("unexpected command %v %v", name, arg)
+	})()
+
+	cases := []struct {
+		User    string
+		Methods []string
+		Name    string
+		Source  string
+	}{
+		{"envuser", nil, "envuser", "env:USER"},
+		{"", nil, "whoamiuser", "whoami"},
+		{"", []string{"id", "whoami"}, "iduser", "id"},
+		{"envuser", []string{"getent", "env"}, "getentuser", "getent"},
+	}
+
+	for _, tc := range cases {
+		os.Setenv("USER", tc.User)
+		if err := SetUserMethodOrder(tc.Methods); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		name, source, err := UserSource()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+		}
+		if name != tc.Name || source != tc.Source {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v", tc, name, source)
+		}
+
+		// The cached user name keeps its source
+		os.Setenv("USER", "stale")
+		name, source, err = UserSource()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+		}
+		if name != tc.Name || source != tc.Source {
+			t.Fatalf("Input: %#v\n\nCached output: %#v, %#v", tc, name, source)
+		}
+		Reset()
+	}
+}
+
+func TestExpandKeepSuffix(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchEnv("HOME", home)()
+	defer patchEnv("USERPROFILE", home)()
+
+	cases := []struct {
+		Input       string
+		SuffixChars string
+		Output      string
+		Err         bool
+	}{
+		{"~/docs/report.pdf", "", filepath.Join(home, "docs", "report.pdf"), false},
+		{"~/docs/report.pdf#page=3", "", filepath.Join(home, "docs", "report.pdf") + "#page=3", false},
+		{"~/search?q=x#top", "", filepath.Join(home, "search") + "?q=x#top", false},
+		{"~/a@b", "@", filepath.Join(home, "a") + "@b", false},
+		{"~/a#b", "@", filepath.Join(home, "a#b"), false},
+		{"/abs#frag", "", "/abs#frag", false},
+		{"#frag", "", "#frag", false},
+		{"~alice/x#frag", "", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandKeepSuffix(tc.Input, tc.SuffixChars)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestSetUseEffectiveUID(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetUseEffectiveUID(false)
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+
+	var queried string
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name != "getent" {
+			return nil, errors.New("not found")
+		}
+		queried = arg[1]
+		return []byte("bob:x:" + arg[1] + ":0::/home/bob:/bin/sh\n"), nil
+	})()
+
+	for _, effective := range []bool{false, true} {
+		SetUseEffectiveUID(effective)
+		expected := strconv.Itoa(os.Getuid())
+		if effective {
+			expected = strconv.Itoa(os.Geteuid())
+		}
+
+		if _, err := Dir(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if queried != expected {
+			t.Fatalf("effective %v: expected getent for uid %v got %v", effective, expected, queried)
+		}
+	}
+}
+
+func TestDirForAll(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+	defer Reset()
+	Reset()
+
+	var calls [][]string
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		calls = append(calls, arg)
+		return []byte("alice:x:1000:1000:Alice:/home/alice:/bin/sh\n" +
+			"bob:x:1001:1001:Bob,,,:/home/bob:/bin/bash\n" +
+			"\n"), nil
+	})()
+
+	actual, err := DirForAll([]string{"alice", "bob", "mallory"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := map[string]string{"alice": "/home/alice", "bob": "/home/bob"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v got %v", expected, actual)
+	}
+	if !reflect.DeepEqual(calls, [][]string{{"passwd", "alice", "bob", "mallory"}}) {
+		t.Fatalf("unexpected getent calls %v", calls)
+	}
+
+	// Cached users are not looked up again.
+	dir, err := DirFor("bob")
+	if err != nil || dir != "/home/bob" {
+		t.Fatalf("DirFor(bob) = %v, %v", dir, err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("unexpected getent calls %v", calls)
+	}
+}
+
+func TestDirShellCWD(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no shell fallback on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	shell := cwd
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "sh" {
+			return []byte(shell + "\n"), nil
+		}
+		return nil, errors.New("not found")
+	})()
+
+	// A bare cd without HOME leaves the shell in the working directory.
+	if dir, err := Dir(); err != ErrNoHomeDir {
+		t.Fatalf("expected ErrNoHomeDir got %v, %v", dir, err)
+	}
+
+	shell = "/home/bob"
+	dir, err := Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/home/bob" {
+		t.Fatalf("expected /home/bob got %v", dir)
+	}
+}
+
+func TestDirModTime(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchEnv("HOME", home)()
+	defer patchEnv("USERPROFILE", home)()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(home, mtime, mtime); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := DirModTime()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !actual.Equal(mtime) {
+		t.Fatalf("expected %v got %v", mtime, actual)
+	}
+
+	os.Setenv("HOME", filepath.Join(home, "missing"))
+	os.Setenv("USERPROFILE", filepath.Join(home, "missing"))
+	var pathErr *fs.PathError
+	if _, err := DirModTime(); !errors.As(err, &pathErr) {
+		t.Fatalf("expected a stat error, got %v", err)
+	}
+}
+
+func TestPreferStdlib(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	home := nativePath("/home/env")
+	defer patchHome(home)()
+
+	// Both code paths agree on a sane environment
+	for _, prefer := range []bool{false, true} {
+		SetPreferStdlib(prefer)
+		dir, source, err := DirSource()
+		if err != nil {
+			t.Fatalf("prefer %v: err: %s", prefer, err)
+		}
+		if dir != home {
+			t.Fatalf("prefer %v: expected %v got %v", prefer, home, dir)
+		}
+		if prefer && source != "stdlib" || !prefer && source == "stdlib" {
+			t.Fatalf("prefer %v: unexpected source %v", prefer, source)
+		}
+	}
+
+	cases := []struct {
+		Stdlib string
+		Err    error
+		Prefer bool
+		Output string
+		Source string
+	}{
+		{nativePath("/home/std/"), nil, true, nativePath("/home/std"), "stdlib"},
+		{nativePath("/home/std"), nil, false, home, ""},
+		{"", errors.New("$HOME is not defined"), true, home, ""},
+		{"relative", nil, true, home, ""},
+	}
+
+	for _, tc := range cases {
+		stdlibHomeDir = func() (string, error) { return tc.Stdlib, tc.Err }
+		SetPreferStdlib(tc.Prefer)
+		dir, source, err := DirSource()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+		}
+		if dir != tc.Output || (tc.Source != "") != (source == "stdlib") {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v", tc, dir, source)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// An unusable home is refused whichever way it was found
+	stdlibHomeDir = func() (string, error) { return "/nonexistent", nil }
+	SetPreferStdlib(true)
+	SetHomeTemplate("")
+	if _, err := Dir(); !errors.Is(err, ErrNoHomeDir) {
+		t.Fatalf("expected ErrNoHomeDir got %v", err)
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix discovery methods")
+	}
+	defer Reset()
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchEnv(OverrideEnv, "")()
+
+	var getent, shell string
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		switch {
+		case name == "getent" && getent != "":
+			return []byte(getent), nil
+		case name == "sh" && shell != "":
+			return []byte(shell), nil
+		}
+		return nil, errors.New("not found")
+	})()
+
+	cases := []struct {
+		Home     string
+		Override string
+		Getent   string
+		Shell    string
+		Output   string
+		Source   string
+	}{
+		{"/home/env", "", "", "", "/home/env", "env:HOME"},
+		{"/home/env", "/pinned", "", "", "/pinned", "env:" + OverrideEnv},
+		{"", "", "bob:x:1000:1000::/home/bob:/bin/sh\n", "", "/home/bob", "getent"},
+		{"", "", "", "/home/sh\n", "/home/sh", "shell"},
+	}
+
+	for _, tc := range cases {
+		Reset()
+		os.Setenv("HOME", tc.Home)
+		os.Setenv(OverrideEnv, tc.Override)
+		getent, shell = tc.Getent, tc.Shell
+
+		// The second call is served from the cache.
+		for i := 0; i < 2; i++ {
+			dir, source, err := DirSource()
+			if err != nil {
+				t.Fatalf("Input: %#v\n\nErr: %s", tc, err)
+			}
+			if dir != tc.Output || source != tc.Source {
+				t.Fatalf("Input: %#v\n\nOutput: %#v %#v", tc, dir, source)
+			}
+		}
+	}
+}
+
+func TestExpandDotDot(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(nativePath("/home/bob"))()
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"~/.", nativePath("/home/bob")},
+		{"~/./x", nativePath("/home/bob/x")},
+		{"~/..", nativePath("/home")},
+		{"~/../x", nativePath("/home/x")},
+		{"~/../../..", nativePath("/")},
+		{"~/x/../y", nativePath("/home/bob/y")},
+	}
+	if runtime.GOOS == "windows" {
+		cases = append(cases, []struct {
+			Input  string
+			Output string
+		}{
+			{`~\.`, nativePath("/home/bob")},
+			{`~\..`, nativePath("/home")},
+			{`~\..\x`, nativePath("/home/x")},
+		}...)
+	}
+
+	for _, tc := range cases {
+		actual, err := Expand(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestDirTrailingSlash(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+
+	cases := []struct {
+		Home   string
+		Output string
+	}{
+		{nativePath("/home/bob/"), nativePath("/home/bob")},
+		{nativePath("/home/bob//"), nativePath("/home/bob")},
+		{nativePath("/home/./bob"), nativePath("/home/bob")},
+		{nativePath("/"), nativePath("/")},
+	}
+
+	for _, tc := range cases {
+		restore := patchHome(tc.Home)
+		actual, err := Dir()
+		restore()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Home, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Home, actual)
+		}
+	}
+}
+
+func TestDirNoShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no shell fallback on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	})()
+
+	_, err := Dir()
+	if !errors.Is(err, ErrNoHomeDir) {
+		t.Fatalf("expected ErrNoHomeDir got %v", err)
+	}
+	if !strings.Contains(err.Error(), "no shell available") {
+		t.Fatalf("expected an informative error, got %v", err)
+	}
+}
+
+func TestDefaultDir(t *testing.T) {
+	defer Reset()
+	defer patchEnv(OverrideEnv, "")()
+	defer func() { DefaultDir = "" }()
+	defaultDir := nativePath("/build/home")
+
+	Dir()
+	Expand("~/foo")
+
+	DefaultDir = defaultDir
+	dir, source, err := DirSource()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != defaultDir || source != "default" {
+		t.Fatalf("expected %v got %v (%v)", defaultDir, dir, source)
+	}
+	actual, err := Expand("~/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(defaultDir, "foo"); actual != expected {
+		t.Fatalf("expected %v got %v", expected, actual)
+	}
+
+	os.Setenv(OverrideEnv, nativePath("/pinned"))
+	if dir, _ := Dir(); dir != nativePath("/pinned") {
+		t.Fatalf("expected the override to win, got %v", dir)
+	}
+}
+
+func TestExpandDuplicateSeparators(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Clean  string
+	}{
+		{"~//foo", filepath.Join(home, "foo"), filepath.Join(home, "foo")},
+		{"~/foo//bar", filepath.Join(home, "foo", "bar"), filepath.Join(home, "foo", "bar")},
+		{"~//foo///bar/", filepath.Join(home, "foo", "bar"), filepath.Join(home, "foo", "bar")},
+		{"/a//b", "/a//b", filepath.Clean("/a//b")},
+		{"", "", ""},
+	}
+
+	for _, tc := range cases {
+		actual, err := Expand(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+
+		actual, err = ExpandClean(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Clean {
+			t.Fatalf("Input: %#v\n\nClean output: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandStrict(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"", "", false},
+		{"/a/b", "/a/b", false},
+		{"~", home, false},
+		{"~/x", filepath.Join(home, "x"), false},
+		{"/a/~b", "", true},
+		{"foo~", "", true},
+		{"~/x~", "", true},
+		{"~~", "", true},
+		{"~alice/x", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandStrict(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestSetUserMethodOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix discovery methods")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetUserMethodOrder(nil)
+	defer patchEnv("USER", "stale")()
+	defer func(bypass bool) { whoamiBypass = bypass }(whoamiBypass)
+	whoamiBypass = false
+
+	var calls []string
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		calls = append(calls, name)
+		switch name {
+		case "whoami":
+			return []byte("fromwhoami\n"), nil
+		case "id":
+			return []byte("uid=1000(fromid) gid=1000(fromid)\n"), nil
+		case "getent":
+			return []byte("fromgetent:x:1000:1000::/home/fromgetent:/bin/sh\n"), nil
+		}
+		return nil, errors.New("not found")
+	})()
+
+	cases := []struct {
+		Order  []string
+		Output string
+		Calls  []string
+	}{
+		{nil, "stale", nil},
+		{[]string{"id", "env"}, "fromid", []string{"id"}},
+		{[]string{"whoami", "id"}, "fromwhoami", []string{"whoami"}},
+		{[]string{"getent"}, "fromgetent", []string{"getent"}},
+	}
+
+	for _, tc := range cases {
+		if err := SetUserMethodOrder(tc.Order); err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Order, err)
+		}
+		calls = nil
+		user, err := User()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Order, err)
+		}
+		if user != tc.Output || !reflect.DeepEqual(calls, tc.Calls) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, calls %v", tc.Order, user, calls)
+		}
+	}
+
+	if err := SetUserMethodOrder([]string{"finger"}); err == nil {
+		t.Fatalf("expected error for unknown method")
+	}
+}
+
+func TestExpandPathListString(t *testing.T) {
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	DisableCache = true
+	defer func() { DisableCache = false }()
+
+	sep := string(os.PathListSeparator)
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"", "", false},
+		{"~/go", filepath.Join(home, "go"), false},
+		{
+			"~/go" + sep + "~/work/go",
+			filepath.Join(home, "go") + sep + filepath.Join(home, "work", "go"),
+			false,
+		},
+		{
+			nativePath("/usr/bin") + sep + sep + "~/bin" + sep + "rel",
+			nativePath("/usr/bin") + sep + sep + filepath.Join(home, "bin") + sep + "rel",
+			false,
+		},
+		{"~/go" + sep + "~alice/go", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandPathListString(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandFrom(t *testing.T) {
+	home := nativePath("/srv/home")
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"", "", false},
+		{"/a/b", "/a/b", false},
+		{"~", home, false},
+		{"~/x", filepath.Join(home, "x"), false},
+		{"~alice/x", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandFrom(home, tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpander(t *testing.T) {
+	defer patchEnv(OverrideEnv, nativePath("/home/ignored"))()
+	home := nativePath("/srv/home")
+	e := Expander{Home: home}
+
+	for _, tc := range []struct{ Input, Output string }{
+		{"~", home},
+		{"~/a/b", filepath.Join(home, "a", "b")},
+		{"/a/b", "/a/b"},
+	} {
+		actual, err := e.Expand(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	if _, err := e.Expand("~alice/x"); err == nil {
+		t.Fatalf("expected error for ~alice/x")
+	}
+}
+
+func TestExpandForUser(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchRun(getentStub(map[string]string{
+		"bob": "bob:x:1000:1000:Bob:/home/bob:/bin/sh",
+	}))()
+
+	cases := []struct {
+		Input  string
+		User   string
+		Output string
+		Err    bool
+	}{
+		{"/a/b", "nobody", "/a/b", false},
+		{"~", "bob", "/home/bob", false},
+		{"~/x", "bob", "/home/bob/x", false},
+		{"~/x", "nobody", "", true},
+		{"~alice/x", "bob", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandForUser(tc.Input, tc.User)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v, %#v\n\nErr: %s", tc.Input, tc.User, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v, %#v\n\nOutput: %#v", tc.Input, tc.User, actual)
+		}
+	}
+}
+
+func TestSetExpandPostProcessor(t *testing.T) {
+	Reset()
+	defer Reset()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	defer SetExpandPostProcessor(nil)
+
+	from := nativePath("/home")
+	to := nativePath("/exported/home")
+	SetExpandPostProcessor(func(path string) string {
+		if strings.HasPrefix(path, from) {
+			return to + path[len(from):]
+		}
+		return path
+	})
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"~/x", filepath.Join(to, "bob", "x")},
+		{"~/x", filepath.Join(to, "bob", "x")},
+		{filepath.Join(from, "alice"), filepath.Join(from, "alice")},
+	}
+
+	for _, tc := range cases {
+		actual, err := Expand(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	list, err := ExpandPathList("~/bin")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(list) != 1 || list[0] != filepath.Join(to, "bob", "bin") {
+		t.Fatalf("ExpandPathList: %#v", list)
+	}
+
+	// The other expansion functions are post-processed as well
+	others := []struct {
+		Name   string
+		F      func() (string, error)
+		Output string
+	}{
+		{"ExpandUser", func() (string, error) { return ExpandUser("~/x") }, filepath.Join(to, "bob", "x")},
+		{"ExpandFrom", func() (string, error) { return ExpandFrom(filepath.Join(from, "carol"), "~/x") }, filepath.Join(to, "carol", "x")},
+		{"ExpandWithMap", func() (string, error) {
+			return ExpandWithMap("~data/x", map[string]string{"data": filepath.Join(from, "data")})
+		}, filepath.Join(to, "data", "x")},
+	}
+	if runtime.GOOS != "windows" {
+		defer patchRun(getentStub(map[string]string{
+			"carol": "carol:x:1001:1001:Carol:/home/carol:/bin/sh",
+		}))()
+		others = append(others, []struct {
+			Name   string
+			F      func() (string, error)
+			Output string
+		}{
+			{"ExpandUser ~carol", func() (string, error) { return ExpandUser("~carol/x") }, "/exported/home/carol/x"},
+			{"ExpandForUser", func() (string, error) { return ExpandForUser("~/x", "carol") }, "/exported/home/carol/x"},
+		}...)
+	}
+	for _, tc := range others {
+		actual, err := tc.F()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Name, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Name, actual)
+		}
+	}
+
+	SetExpandPostProcessor(nil)
+	if actual, _ := Expand("~/x"); actual != filepath.Join(home, "x") {
+		t.Fatalf("after reset: %#v", actual)
+	}
+}
+
+func TestExpandOrKeep(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer SetDirEnvChain(nil)
+
+	if actual := ExpandOrKeep("~/x"); actual != filepath.Join(home, "x") {
+		t.Fatalf("Output: %#v", actual)
+	}
+	if actual := ExpandOrKeep("~alice/x"); actual != "~alice/x" {
+		t.Fatalf("Output: %#v", actual)
+	}
+
+	// Make Dir() fail.
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	})()
+	if _, err := Dir(); err == nil {
+		t.Fatalf("expected Dir() to fail")
+	}
+
+	for _, path := range []string{"~/x", "~", "/a/b", ""} {
+		if actual := ExpandOrKeep(path); actual != path {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", path, actual)
+		}
+	}
+}
+
+func TestCRLFOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("discovery commands are not used on windows")
+	}
+
+	Reset()
+	defer Reset()
+	defer SetUserMethodOrder(nil)
+	defer patchEnv("HOME", "")()
+	defer patchEnv("USER", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer func(bypass bool) { whoamiBypass = bypass }(whoamiBypass)
+	whoamiBypass = false
+	uid := strconv.Itoa(os.Getuid())
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		switch name {
+		case "whoami":
+			return []byte("bob\r\n"), nil
+		case "id":
+			return []byte("uid=1000(bob)\r gid=1000(bob) groups=1000(bob)\r\n"), nil
+		case "getent":
+			return []byte("bob:x:" + uid + ":1000:Bob:/home/bob:/bin/sh\r\n"), nil
+		}
+		return nil, errors.New("not found")
+	})()
+
+	for _, order := range [][]string{{"whoami"}, {"id"}, {"getent"}} {
+		if err := SetUserMethodOrder(order); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if user, err := User(); err != nil || user != "bob" {
+			t.Fatalf("Input: %v\n\nOutput: %#v, %v", order, user, err)
+		}
+	}
+
+	if dir, err := Dir(); err != nil || dir != "/home/bob" {
+		t.Fatalf("Dir: %#v, %v", dir, err)
+	}
+	entry, err := PasswdEntry()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if entry.Shell != "/bin/sh" {
+		t.Fatalf("Shell: %#v", entry.Shell)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer func() { AllowJoinEscape = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  []string
+		Output string
+		Escape string
+	}{
+		{nil, home, home},
+		{[]string{"a", "b"}, filepath.Join(home, "a", "b"), filepath.Join(home, "a", "b")},
+		{[]string{"a", "..", "b"}, filepath.Join(home, "b"), filepath.Join(home, "b")},
+		{[]string{".."}, "", nativePath("/home")},
+		{[]string{"a", "../../alice"}, "", nativePath("/home/alice")},
+		{[]string{"..bob"}, filepath.Join(home, "..bob"), filepath.Join(home, "..bob")},
+		{[]string{"a", nativePath("/etc")}, "", ""},
+	}
+
+	for _, escape := range []bool{false, true} {
+		AllowJoinEscape = escape
+		for _, tc := range cases {
+			expected := tc.Output
+			if escape {
+				expected = tc.Escape
+			}
+
+			actual, err := Join(tc.Input...)
+			if (err != nil) != (expected == "") {
+				t.Fatalf("Input: %#v, escape %v\n\nErr: %v", tc.Input, escape, err)
+			}
+			if actual != expected {
+				t.Fatalf("Input: %#v, escape %v\n\nOutput: %#v", tc.Input, escape, actual)
+			}
+		}
+	}
+}
+
+func TestSetHomeTemplate(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetHomeTemplate("")
+	defer SetDirEnvChain(nil)
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchEnv("USER", "bob")()
+	defer patchEnv("USERNAME", "bob")()
+
+	// Make every discovery method fail.
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	})()
+	if _, err := Dir(); err == nil {
+		t.Fatalf("expected Dir() to fail")
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	cases := []struct {
+		Template string
+		Output   string
+	}{
+		{nativePath("/home/{user}"), nativePath("/home/bob")},
+		{nativePath("/data/{uid}/{user}/"), nativePath("/data/" + uid + "/bob")},
+		{"home/{user}", ""},
+	}
+	if runtime.GOOS == "windows" {
+		cases[1].Output = ""
+	}
+
+	for _, tc := range cases {
+		SetHomeTemplate(tc.Template)
+		dir, source, err := DirSource()
+		if (err != nil) != (tc.Output == "") {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Template, err)
+		}
+		if dir != tc.Output || (err == nil && source != "template") {
+			t.Fatalf("Input: %#v\n\nOutput: %#v (%s)", tc.Template, dir, source)
+		}
+	}
+
+	// A template is not used when a placeholder cannot be filled in.
+	os.Setenv("USER", "")
+	os.Setenv("USERNAME", "")
+	SetHomeTemplate(nativePath("/home/{user}"))
+	if dir, err := Dir(); err == nil {
+		t.Fatalf("expected an error, got %v", dir)
+	}
+
+	if runtime.GOOS != "windows" {
+		if id, err := UserID(); err != nil || id != uid {
+			t.Fatalf("UserID() = %v, %v", id, err)
+		}
+	}
+}
+
+func TestSetCacheTTL(t *testing.T) {
+	Reset()
+	defer Reset()
+	defer SetCacheTTL(0)
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+
+	defer patchEnv("USER", "bob")()
+	defer patchEnv("USERNAME", "bob")()
+	first := nativePath("/home/bob")
+	second := nativePath("/home/robert")
+	defer patchHome(first)()
+
+	SetCacheTTL(time.Hour)
+	if dir, _ := Dir(); dir != first {
+		t.Fatalf("Dir: %#v", dir)
+	}
+	if expanded, _ := Expand("~/x"); expanded != filepath.Join(first, "x") {
+		t.Fatalf("Expand: %#v", expanded)
+	}
+	if user, _ := User(); user != "bob" {
+		t.Fatalf("User: %#v", user)
+	}
+
+	patchHome(second)
+	os.Setenv("USER", "robert")
+	os.Setenv("USERNAME", "robert")
+
+	clock = clock.Add(59 * time.Minute)
+	if dir, _ := Dir(); dir != first {
+		t.Fatalf("Dir before expiry: %#v", dir)
+	}
+	if user, _ := User(); user != "bob" {
+		t.Fatalf("User before expiry: %#v", user)
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	if expanded, _ := Expand("~/x"); expanded != filepath.Join(second, "x") {
+		t.Fatalf("Expand after expiry: %#v", expanded)
+	}
+	if dir, _ := Dir(); dir != second {
+		t.Fatalf("Dir after expiry: %#v", dir)
+	}
+	if user, _ := User(); user != "robert" {
+		t.Fatalf("User after expiry: %#v", user)
+	}
+
+	// A zero TTL caches forever.
+	SetCacheTTL(0)
+	patchHome(first)
+	clock = clock.Add(1000 * time.Hour)
+	if dir, _ := Dir(); dir != second {
+		t.Fatalf("Dir with zero TTL: %#v", dir)
+	}
+}
+
+func TestSetCacheIdleTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = false
+	Reset()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	lookups := 0
+	stub := getentStub(map[string]string{
+		"alice": "alice:x:1001:1001::/home/alice:/bin/sh",
+		"bob":   "bob:x:1002:1002::/home/bob:/bin/sh",
+	})
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		lookups++
+		return stub(env, name, arg...)
+	})
+
+	SetCacheIdleTimeout(time.Hour)
+	DirFor("alice")
+	DirFor("bob")
+
+	// Using alice keeps it cached well past an hour after it was looked up
+	for i := 0; i < 3; i++ {
+		clock = clock.Add(40 * time.Minute)
+		if dir, err := DirFor("alice"); err != nil || dir != "/home/alice" {
+			t.Fatalf("expected /home/alice got %v: %v", dir, err)
+		}
+	}
+	if lookups != 2 {
+		t.Fatalf("expected alice to stay cached, got %d lookups", lookups)
+	}
+
+	// bob was idle and has been swept
+	if _, ok := userDirCache["bob"]; ok {
+		t.Fatalf("expected idle bob to be evicted: %v", userDirCache)
+	}
+	if dir, err := DirFor("bob"); err != nil || dir != "/home/bob" || lookups != 3 {
+		t.Fatalf("expected a fresh lookup of bob, got %v after %d lookups: %v", dir, lookups, err)
+	}
+
+	// An entry idle for the timeout is looked up again
+	clock = clock.Add(time.Hour)
+	DirFor("alice")
+	if lookups != 4 {
+		t.Fatalf("expected idle alice to be looked up again, got %d lookups", lookups)
+	}
+
+	// A zero timeout keeps entries however long they are idle
+	SetCacheIdleTimeout(0)
+	clock = clock.Add(1000 * time.Hour)
+	DirFor("alice")
+	if lookups != 4 {
+		t.Fatalf("expected alice to stay cached, got %d lookups", lookups)
+	}
+}
+
+func TestExpandSliceInPlace(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	backing := []string{"~/a", "/b", "", "~"}
+	paths := backing[:3]
+	if err := ExpandSliceInPlace(paths); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{filepath.Join(home, "a"), "/b", "", "~"}
+	if !reflect.DeepEqual(backing, expected) {
+		t.Fatalf("expected %#v got %#v", expected, backing)
+	}
+
+	paths = []string{"~/a", "/b", "~alice/c"}
+	err := ExpandSliceInPlace(paths)
+	if err == nil || !strings.Contains(err.Error(), "element 2") {
+		t.Fatalf("expected an error naming element 2, got %v", err)
+	}
+	if paths[0] != "~/a" {
+		t.Fatalf("paths modified on error: %#v", paths)
+	}
+
+	if err := ExpandSliceInPlace(nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSetShellFallbackEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no shell fallback on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetShellFallbackEnv(nil)
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchEnv("BASH_ENV", "/etc/noisy.sh")()
+
+	var shellEnv []string
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name != "sh" {
+			return nil, errors.New("not found")
+		}
+		// Simulate a shell whose startup file prints a banner.
+		shellEnv = env
+		out := "/home/bob\n"
+		if env == nil {
+			env = os.Environ()
+		}
+		for _, kv := range env {
+			if strings.HasPrefix(kv, "BASH_ENV=") {
+				out = "Welcome!\n" + out
+			}
+		}
+		return []byte(out), nil
+	})()
+
+	dir, err := Dir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/home/bob" {
+		t.Fatalf("expected /home/bob got %#v", dir)
+	}
+	expected := []string{"PATH=" + os.Getenv("PATH"), "LC_ALL=C"}
+	if !reflect.DeepEqual(shellEnv, expected) {
+		t.Fatalf("expected env %v got %v", expected, shellEnv)
+	}
+
+	SetShellFallbackEnv([]string{"PATH=/bin"})
+	if _, err := Dir(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(shellEnv, []string{"PATH=/bin"}) {
+		t.Fatalf("unexpected env %v", shellEnv)
+	}
+}
+
+func TestExpandIdempotent(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	inputs := []string{"", "~", "~/", "~/x", "~//x/../y/", "/a//b", "relative/x", "x~", "/a/~b"}
+	for _, input := range inputs {
+		for name, f := range map[string]func(string) (string, error){
+			"Expand":      Expand,
+			"ExpandClean": ExpandClean,
+		} {
+			once, err := f(input)
+			if err != nil {
+				t.Fatalf("%s(%#v): %s", name, input, err)
+			}
+			twice, err := f(once)
+			if err != nil || twice != once {
+				t.Fatalf("%s(%#v): %#v then %#v, %v", name, input, once, twice, err)
+			}
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	defer patchRun(getentStub(map[string]string{
+		"alice": "alice:x:1001:1001::/home/alice:/bin/sh",
+	}))()
+	for _, input := range inputs {
+		once, err := ExpandForUser(input, "alice")
+		if err != nil {
+			t.Fatalf("ExpandForUser(%#v): %s", input, err)
+		}
+		twice, err := ExpandForUser(once, "alice")
+		if err != nil || twice != once {
+			t.Fatalf("ExpandForUser(%#v): %#v then %#v, %v", input, once, twice, err)
+		}
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix discovery methods")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetLogger(nil)
+	defer patchEnv("HOME", "")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		if name == "sh" {
+			return []byte("/home/bob\n"), nil
+		}
+		return nil, errors.New("exit status 2")
+	})()
+
+	var events []string
+	SetLogger(func(msg string, keyvals ...interface{}) {
+		if len(keyvals)%2 != 0 {
+			t.Fatalf("odd key-values for %q: %v", msg, keyvals)
+		}
+		events = append(events, strings.TrimSuffix(fmt.Sprintln(append([]interface{}{msg}, keyvals...)...), "\n"))
+	})
+
+	if dir, err := Dir(); err != nil || dir != "/home/bob" {
+		t.Fatalf("Dir: %#v, %v", dir, err)
+	}
+	expected := []string{
+		"trying env var HOME",
+		"trying getent uid " + strconv.Itoa(os.Getuid()),
+		"getent failed err exit status 2",
+		"falling back to shell shell sh",
+		"shell returned home /home/bob",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected %q got %q", expected, events)
+	}
+
+	events = nil
+	os.Setenv("HOME", "/home/alice")
+	Dir()
+	expected = []string{"trying env var HOME", "env has home var HOME home /home/alice"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected %q got %q", expected, events)
+	}
+
+	SetLogger(nil)
+	events = nil
+	Dir()
+	if len(events) != 0 {
+		t.Fatalf("unexpected events after SetLogger(nil): %q", events)
+	}
+}
+
+func TestDirWritable(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	writable, err := DirWritable()
+	if err != nil || !writable {
+		t.Fatalf("DirWritable() = %v, %v", writable, err)
+	}
+	if entries, _ := os.ReadDir(home); len(entries) != 0 {
+		t.Fatalf("probe file left behind: %v", entries)
+	}
+
+	// Root can write to read-only directories.
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		if err := os.Chmod(home, 0555); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		writable, err := DirWritable()
+		os.Chmod(home, 0755)
+		if err != nil || writable {
+			t.Fatalf("read-only: DirWritable() = %v, %v", writable, err)
+		}
+	}
+
+	// A home that cannot be resolved is an error, not just unwritable.
+	defer SetDirEnvChain(nil)
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	})()
+	if writable, err := DirWritable(); err == nil || writable {
+		t.Fatalf("unresolvable: DirWritable() = %v, %v", writable, err)
+	}
+}
+
+func TestSetDiscoveryStrategy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix discovery methods")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetDiscoveryStrategy("")
+	defer patchEnv("HOME", "/home/env")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+
+	var calls []string
+	uid := strconv.Itoa(os.Getuid())
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		calls = append(calls, name)
+		return getentStub(map[string]string{
+			uid: "bob:x:" + uid + ":1000::/home/passwd:/bin/sh",
+		})(env, name, arg...)
+	})()
+
+	cases := []struct {
+		Strategy string
+		Home     string
+		Output   string
+		Source   string
+		Calls    []string
+	}{
+		{"", "/home/env", "/home/env", "env:HOME", nil},
+		{"env-first", "", "/home/passwd", "getent", []string{"getent"}},
+		{"passwd-first", "/home/env", "/home/passwd", "getent", []string{"getent"}},
+		{"env-only", "/home/env", "/home/env", "env:HOME", nil},
+		{"env-only", "", "", "", nil},
+	}
+
+	for _, tc := range cases {
+		if err := SetDiscoveryStrategy(tc.Strategy); err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Strategy, err)
+		}
+		os.Setenv("HOME", tc.Home)
+		calls = nil
+
+		dir, source, err := DirSource()
+		if tc.Output == "" {
+			if !errors.Is(err, ErrNoHomeDir) {
+				t.Fatalf("Input: %#v\n\nexpected ErrNoHomeDir, got %v", tc.Strategy, err)
+			}
+		} else if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Strategy, err)
+		}
+		if dir != tc.Output || source != tc.Source || !reflect.DeepEqual(calls, tc.Calls) {
+			t.Fatalf("Input: %#v, HOME %#v\n\nOutput: %#v (%s), calls %v", tc.Strategy, tc.Home, dir, source, calls)
+		}
+	}
+
+	if err := SetDiscoveryStrategy("shell-first"); err == nil {
+		t.Fatalf("expected error for unknown strategy")
+	}
+}
+
+func TestExpandOrRel(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"", ""},
+		{"foo/bar", filepath.Join("foo", "bar")},
+		{"./foo//bar/", filepath.Join("foo", "bar")},
+		{"foo/../..", ".."},
+		{".", "."},
+		{"~/x", filepath.Join(home, "x")},
+		{"/a//b", "/a//b"},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandOrRel(tc.Input)
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+		if tc.Input != "" && tc.Input[0] != '~' && filepath.IsAbs(actual) != filepath.IsAbs(tc.Input) {
+			t.Fatalf("Input: %#v\n\nOutput changed absoluteness: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandCSV(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output []string
+		Err    bool
+	}{
+		{"", nil, false},
+		{"~/a,~/b,/etc/c", []string{filepath.Join(home, "a"), filepath.Join(home, "b"), "/etc/c"}, false},
+		{" ~/a , /etc/c ,\t~ ", []string{filepath.Join(home, "a"), "/etc/c", home}, false},
+		{",~/a,, ,/etc/c,", []string{filepath.Join(home, "a"), "/etc/c"}, false},
+		{"~/a,~alice/b", nil, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandCSV(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if !reflect.DeepEqual(actual, tc.Output) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestDirBytes(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	b, err := DirBytes()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(b) != home {
+		t.Fatalf("expected %v got %s", home, b)
+	}
+
+	// Each call returns a slice of its own
+	b[0] = 'x'
+	if again, _ := DirBytes(); string(again) != home {
+		t.Fatalf("expected %v got %s", home, again)
+	}
+}
+
+func TestExpandWithMap(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cfg := nativePath("/etc/app")
+	data := nativePath("/var/lib/app")
+	roots := map[string]string{"cfg": cfg, "data": data}
+
+	cases := []struct {
+		Input  string
+		Roots  map[string]string
+		Output string
+		Err    bool
+	}{
+		{"~", roots, home, false},
+		{"~/x", roots, filepath.Join(home, "x"), false},
+		{"~cfg", roots, cfg, false},
+		{"~cfg/app.toml", roots, filepath.Join(cfg, "app.toml"), false},
+		{"~data//db/../db", roots, filepath.Join(data, "db"), false},
+		{"~/x", map[string]string{"": data}, filepath.Join(data, "x"), false},
+		{"/a/~cfg", roots, "/a/~cfg", false},
+		{"~xyz/x", roots, "", true},
+		{"~cfgx", roots, "", true},
+		{"~cfg/x", nil, "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandWithMap(tc.Input, tc.Roots)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandSafe(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"~/x", filepath.Join(home, "x"), false},
+		{"~", home, false},
+		{"~/..", filepath.Dir(home), false},
+		{"~/../..", "", true},
+		{"~/../../..", "", true},
+		{"/", "", true},
+		{"/a/..", "", true},
+		{"relative", "relative", false},
+		{"", "", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandSafe(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	defer patchHome(nativePath("/"))()
+	if dir, err := ExpandSafe("~"); err == nil {
+		t.Fatalf("expected error for a root home, got %v", dir)
+	}
+}
+
+func TestExpandUserRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome("/home/bob")()
+
+	cases := []struct {
+		Input  string
+		Entry  string
+		Output string
+		Err    bool
+	}{
+		{"~root/x", "root:x:0:0:root:/root:/bin/bash", "/root/x", false},
+		{"~root", "root:x:0:0:root:/root:/bin/bash", "/root", false},
+		{"~root/../etc", "root:x:0:0:root:/root:/bin/bash", "/etc", false},
+		{"~root/x", "root:x:0:0:root:/:/bin/sh", "/x", false},
+		{"~root", "root:x:0:0:root:/:/bin/sh", "/", false},
+		{"~root/x", "", "", true},
+		{"~/x", "", "/home/bob/x", false},
+		{"/root/x", "", "/root/x", false},
+	}
+
+	for _, tc := range cases {
+		entries := map[string]string{}
+		if tc.Entry != "" {
+			entries["root"] = tc.Entry
+		}
+		restore := patchRun(getentStub(entries))
+		actual, err := ExpandUser(tc.Input)
+		restore()
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestSetMinUID(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = false
+	Reset()
+	patchRun(getentStub(map[string]string{
+		"alice":    "alice:x:1000:1000:Alice:/home/alice:/bin/bash",
+		"www-data": "www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin",
+		"daemonx":  "daemonx:x:1500:1500::/nonexistent:/bin/sh",
+		"ftp":      "ftp:x:1200:1200::/srv/ftp:/bin/false",
+	}))
+
+	// Permissive by default
+	if dir, err := DirFor("www-data"); err != nil || dir != "/var/www" {
+		t.Fatalf("expected /var/www got %v, %v", dir, err)
+	}
+
+	SetMinUID(1000)
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"~alice/x", "/home/alice/x"},
+		{"~www-data/x", ""},
+		{"~daemonx", ""},
+		{"~ftp/pub", ""},
+	}
+	for _, tc := range cases {
+		actual, err := ExpandUser(tc.Input)
+		if tc.Output == "" {
+			if !errors.Is(err, ErrRestrictedAccount) {
+				t.Fatalf("Input: %#v\n\nexpected ErrRestrictedAccount, got %q, %v", tc.Input, actual, err)
+			}
+			continue
+		}
+		if err != nil || actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %v", tc.Input, actual, err)
+		}
+	}
+
+	dirs, err := DirForAll([]string{"alice", "www-data"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(dirs, map[string]string{"alice": "/home/alice"}) {
+		t.Fatalf("unexpected DirForAll result %v", dirs)
+	}
+
+	SetMinUID(0)
+	if dir, err := DirFor("www-data"); err != nil || dir != "/var/www" {
+		t.Fatalf("expected /var/www got %v, %v", dir, err)
+	}
+}
+
+func TestSamePath(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	if resolved, err := filepath.EvalSymlinks(home); err == nil {
+		home = resolved
+	}
+	defer patchHome(home)()
+	defer patchEnv("HOMEDIR_TEST_APP", "app")()
+
+	target := filepath.Join(home, "x")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	link := filepath.Join(home, "link")
+	symlinks := os.Symlink(target, link) == nil
+
+	cases := []struct {
+		A, B string
+		Same bool
+	}{
+		{"~/x", target, true},
+		{"$HOME/x", "~/x", true},
+		{"${HOME}/app", "~/$HOMEDIR_TEST_APP", true},
+		{"~/x/../x/", target, true},
+		{"~/x", "~/y", false},
+		{"~/missing", filepath.Join(home, "missing"), true},
+		{"~", home, true},
+	}
+	if symlinks {
+		cases = append(cases, struct {
+			A, B string
+			Same bool
+		}{"~/link", "~/x", true})
+	}
+	if caseInsensitiveFS() {
+		cases = append(cases, struct {
+			A, B string
+			Same bool
+		}{"~/X", strings.ToUpper(target), true})
+	}
+
+	for _, tc := range cases {
+		same, err := SamePath(tc.A, tc.B)
+		if err != nil {
+			t.Fatalf("Input: %#v, %#v\n\nErr: %s", tc.A, tc.B, err)
+		}
+		if same != tc.Same {
+			t.Fatalf("Input: %#v, %#v\n\nOutput: %v", tc.A, tc.B, same)
+		}
+	}
+
+	if _, err := SamePath("$HOMEDIR_TEST_NEVER_SET/x", "~/x"); err == nil {
+		t.Fatalf("expected error for an unset variable")
+	}
+}
+
+func TestResetClearsEverything(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = false
+	Reset()
+	defer patchHome("/home/bob")()
+	defer patchEnv("USER", "bob")()
+	uid := strconv.Itoa(os.Getuid())
+	patchRun(getentStub(map[string]string{
+		uid:     "bob:x:" + uid + ":100::/home/bob:/bin/sh",
+		"alice": "alice:x:1001:100::/home/alice:/bin/sh",
+	}))
+
+	populate := func() {
+		if _, err := Dir(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := User(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := DirFor("alice"); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := PasswdEntry(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := Expand("~/x"); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	populate()
+	cacheLock.Lock()
+	qualifiedUserCache["NameSamCompatible"] = `DOMAIN\bob`
+	cacheLock.Unlock()
+
+	Reset()
+	cacheLock.RLock()
+	empty := homedirCache == "" && homedirSource == "" && homedirCachedAt.IsZero() &&
+		userCache == "" && userCachedAt.IsZero() &&
+		len(userDirCache) == 0 && len(userDirCachedAt) == 0 &&
+		len(qualifiedUserCache) == 0 && passwdCache == nil &&
+		len(expandCache) == 0 && len(expandCacheOrder) == 0
+	cacheLock.RUnlock()
+	if !empty {
+		t.Fatalf("caches not cleared by Reset")
+	}
+
+	// Everything is recomputed on the next access
+	defer patchHome("/home/robert")()
+	defer patchEnv("USER", "robert")()
+	patchRun(getentStub(map[string]string{
+		uid:     "robert:x:" + uid + ":100::/home/robert:/bin/sh",
+		"alice": "alice:x:1001:100::/srv/alice:/bin/sh",
+	}))
+	populate()
+	if dir, _ := Dir(); dir != "/home/robert" {
+		t.Fatalf("expected /home/robert got %v", dir)
+	}
+	if user, _ := User(); user != "robert" {
+		t.Fatalf("expected robert got %v", user)
+	}
+	if dir, _ := DirFor("alice"); dir != "/srv/alice" {
+		t.Fatalf("expected /srv/alice got %v", dir)
+	}
+	if entry, _ := PasswdEntry(); entry.Name != "robert" {
+		t.Fatalf("expected robert got %v", entry.Name)
+	}
+	if path, _ := Expand("~/x"); path != "/home/robert/x" {
+		t.Fatalf("expected /home/robert/x got %v", path)
+	}
+}
+
+func TestExpandUnique(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	if resolved, err := filepath.EvalSymlinks(home); err == nil {
+		home = resolved
+	}
+	defer patchHome(home)()
+	logs := filepath.Join(home, "logs")
+	if err := os.Mkdir(logs, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	homeVar := "$HOME"
+	if runtime.GOOS == "windows" {
+		homeVar = "$USERPROFILE"
+	}
+	input := []string{"~/logs", homeVar + "/logs", logs, logs + string(filepath.Separator), "~/data", "~/logs/../data", "/srv/x"}
+	if os.Symlink(logs, filepath.Join(home, "link")) == nil {
+		input = append(input, "~/link")
+	}
+	if caseInsensitiveFS() {
+		input = append(input, "~/LOGS")
+	}
+
+	actual, err := ExpandUnique(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{logs, filepath.Join(home, "data"), filepath.Clean("/srv/x")}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Input: %#v\n\nOutput: %#v", input, actual)
+	}
+
+	if _, err := ExpandUnique([]string{"~/x", "$HOMEDIR_TEST_NEVER_SET/x"}); err == nil {
+		t.Fatalf("expected error for an unset variable")
+	}
+}
+
+func TestDirForMaliciousUsername(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		t.Fatalf("unexpected command %v %v", name, arg)
+		return nil, nil
+	})()
+
+	for _, input := range []string{"~a;rm -rf/", "~a\x00b/x", "~-s/x", "~a\nb/x", "~a\x1bb"} {
+		if actual, err := ExpandUser(input); err == nil {
+			t.Fatalf("Input: %#v\n\nexpected error, got %v", input, actual)
+		}
+	}
+	for _, input := range []string{"a;rm -rf/", "-s", "a\tb", "a/b", ""} {
+		if actual, err := DirFor(input); err == nil {
+			t.Fatalf("Input: %#v\n\nexpected error, got %v", input, actual)
+		}
+	}
+	if _, err := DirForAll([]string{"alice", "a/../b"}); err == nil {
+		t.Fatalf("expected error for an invalid user name")
+	}
+
+	for _, input := range []string{"a;b", "a b", "$(id)", "`id`", "a|b", "a&&b"} {
+		if actual, err := DirFor(input); err == nil {
+			t.Fatalf("Input: %#v\n\nexpected error, got %v", input, actual)
+		}
+	}
+}
+
+func TestDirWithEnv(t *testing.T) {
+	defer Snapshot()()
+	defer patchHome(nativePath("/home/mine"))()
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		t.Fatalf("unexpected command %v %v", name, arg)
+		return nil, nil
+	})
+	SetDirEnvChain([]string{"HOME"})
+
+	cases := []struct {
+		Env    []string
+		Output string
+		Err    bool
+	}{
+		{[]string{"PATH=/bin", "HOME=" + nativePath("/home/bob/")}, nativePath("/home/bob"), false},
+		{[]string{"HOME=" + nativePath("/home/a"), "HOME=" + nativePath("/home/b")}, nativePath("/home/b"), false},
+		{[]string{"HOME=relative"}, "", true},
+		{[]string{"HOME="}, "", true},
+		{[]string{"HOME=/nonexistent"}, "", true},
+		{[]string{"USER=bob", "=C:=C:\\"}, "", true},
+		{nil, "", true},
+		{[]string{OverrideEnv + "=/pinned", "HOME=" + nativePath("/home/bob")}, "/pinned", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := DirWithEnv(tc.Env)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Env, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Env, actual)
+		}
+	}
+
+	// The process environment is left alone
+	if home := os.Getenv("HOME"); home != nativePath("/home/mine") {
+		t.Fatalf("expected HOME %v got %v", nativePath("/home/mine"), home)
+	}
+
+	SetDirEnvChain([]string{"HOMEDRIVE+HOMEPATH"})
+	if dir, err := DirWithEnv([]string{"HOMEDRIVE=" + nativePath("/home"), "HOMEPATH=" + string(filepath.Separator) + "bob"}); err != nil || dir != nativePath("/home/bob") {
+		t.Fatalf("expected %v got %v, %v", nativePath("/home/bob"), dir, err)
+	}
+
+	// With SetPreferStdlib the variable os.UserHomeDir reads comes first
+	SetPreferStdlib(true)
+	env := []string{"HOMEDRIVE=" + nativePath("/home"), "HOMEPATH=" + string(filepath.Separator) + "bob", stdlibHomeEnv() + "=" + nativePath("/home/stdlib")}
+	if dir, err := DirWithEnv(env); err != nil || dir != nativePath("/home/stdlib") {
+		t.Fatalf("expected %v got %v, %v", nativePath("/home/stdlib"), dir, err)
+	}
+}
+
+func TestPinnedHomeFlowsEverywhere(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = false
+	Reset()
+	defer patchHome(nativePath("/home/machine"))()
+	defer patchEnv("XDG_CONFIG_HOME", nativePath("/machine/config"))()
+	defer patchEnv("XDG_CACHE_HOME", nativePath("/machine/cache"))()
+	defer patchEnv("XDG_DATA_HOME", nativePath("/machine/data"))()
+	defer patchEnv("AppData", nativePath("/machine/roaming"))()
+	defer patchEnv("LocalAppData", nativePath("/machine/local"))()
+
+	// Warm the cache with the machine's home directory
+	if _, err := Expand("~/x"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	pinned := nativePath("/pinned/home")
+	check := func(how string) {
+		join := func(elem ...string) string {
+			return filepath.Join(append([]string{pinned}, elem...)...)
+		}
+		config, cache, data := join(".config"), join(".cache"), join(".local", "share")
+		switch runtime.GOOS {
+		case "windows":
+			config, cache, data = join("AppData", "Roaming"), join("AppData", "Local"), join("AppData", "Local")
+		case "darwin":
+			config, cache, data = join("Library", "Application Support"), join("Library", "Caches"), join("Library", "Application Support")
+		}
+
+		cases := []struct {
+			Name   string
+			Func   func() (string, error)
+			Output string
+		}{
+			{"Dir", Dir, pinned},
+			{"Expand", func() (string, error) { return Expand("~/x") }, join("x")},
+			{"ExpandClean", func() (string, error) { return ExpandClean("~/a/../x") }, join("x")},
+			{"ExpandEnv", func() (string, error) { return ExpandEnv("~/x") }, join("x")},
+			{"ExpandPathListString", func() (string, error) { return ExpandPathListString("~/x") }, join("x")},
+			{"Collapse", func() (string, error) { return Collapse(join("x")) }, filepath.Join("~", "x")},
+			{"Join", func() (string, error) { return Join("x") }, join("x")},
+			{"ConfigDir", ConfigDir, config},
+			{"CacheDir", CacheDir, cache},
+			{"DataDir", DataDir, data},
+			{"SSHDir", SSHDir, join(".ssh")},
+		}
+		for _, tc := range cases {
+			actual, err := tc.Func()
+			if err != nil {
+				t.Fatalf("%s with %s: err: %s", tc.Name, how, err)
+			}
+			if actual != tc.Output {
+				t.Fatalf("%s with %s: expected %v got %v", tc.Name, how, tc.Output, actual)
+			}
+		}
+	}
+
+	restore := patchEnv(OverrideEnv, pinned)
+	check(OverrideEnv)
+	restore()
+
+	DefaultDir = pinned
+	check("DefaultDir")
+}
+
+func TestExpandUserReport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome("/home/alice")()
+	defer patchRun(getentStub(map[string]string{
+		"bob": "bob:x:1000:1000:Bob:/home/bob:/bin/sh",
+	}))()
+
+	cases := []struct {
+		Input    string
+		Output   string
+		Username string
+		Home     string
+		Err      bool
+	}{
+		{"~bob/x", "/home/bob/x", "bob", "/home/bob", false},
+		{"~bob", "/home/bob", "bob", "/home/bob", false},
+		{"~/x", "/home/alice/x", "", "/home/alice", false},
+		{"~", "/home/alice", "", "/home/alice", false},
+		{"/etc/x", "/etc/x", "", "", false},
+		{"~carol/x", "", "", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, username, home, err := ExpandUserReport(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output || username != tc.Username || home != tc.Home {
+			t.Fatalf("Input: %#v\n\nOutput: %#v, %#v, %#v", tc.Input, actual, username, home)
+		}
+	}
+}
+
+func TestUserConsistent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	defer patchRun(getentStub(map[string]string{
+		uid: "bob:x:" + uid + ":100::/home/bob:/bin/sh",
+	}))()
+
+	cases := []struct {
+		User       string
+		Consistent bool
+	}{
+		{"bob", true},
+		{"mallory", false},
+		{"Bob", false},
+		{"", true},
+	}
+
+	for _, tc := range cases {
+		restore := patchEnv("USER", tc.User)
+		consistent, err := UserConsistent()
+		restore()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.User, err)
+		}
+		if consistent != tc.Consistent {
+			t.Fatalf("Input: %#v\n\nOutput: %v", tc.User, consistent)
+		}
+	}
+
+	defer patchEnv("USER", "bob")()
+	defer patchRun(getentStub(nil))()
+	if _, err := UserConsistent(); err == nil {
+		t.Fatalf("expected error without a passwd entry")
+	}
+}
+
+func TestExpandLenient(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = true
+	defer patchHome("/home/alice")()
+	patchRun(getentStub(map[string]string{
+		"bob": "bob:x:1000:1000:Bob:/home/bob:/bin/sh",
+		"svc": "svc:x:100:100::/var/svc:/usr/sbin/nologin",
+	}))
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"~/x", "/home/alice/x", false},
+		{"~bob/x", "/home/bob/x", false},
+		{"~foo/x", "~foo/x", false},
+		{"~foo", "~foo", false},
+		{"~{{.Stage}}/x", "~{{.Stage}}/x", false},
+		{"/etc/~foo", "/etc/~foo", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandLenient(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	if _, err := Expand("~foo/x"); err == nil {
+		t.Fatalf("Expand should reject ~foo/x")
+	}
+
+	SetMinUID(1000)
+	if actual, err := ExpandLenient("~svc/x"); !errors.Is(err, ErrRestrictedAccount) {
+		t.Fatalf("expected ErrRestrictedAccount got %q, %v", actual, err)
+	}
+
+	// A known user without a home directory is an error, not unknown
+	patchRun(getentStub(map[string]string{
+		"nohome": "nohome:x:1001:1001::::/bin/sh",
+	}))
+	if actual, err := ExpandLenient("~nohome/x"); err == nil || errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected an error for ~nohome/x got %q, %v", actual, err)
+	}
+	if _, err := DirFor("carol"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser got %v", err)
+	}
+	if _, err := DirFor("-carol"); !errors.Is(err, ErrInvalidUsername) {
+		t.Fatalf("expected ErrInvalidUsername got %v", err)
+	}
+}
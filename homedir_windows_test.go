@@ -0,0 +1,212 @@
+//go:build windows
+
+package homedir
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUIDGuard(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		t.Fatalf("unexpected command %v %v", name, arg)
+		return nil, nil
+	})()
+	defer patchEnv("SUDO_USER", "")()
+	defer patchEnv("DOAS_USER", "")()
+	defer patchEnv("PKEXEC_UID", "1000")()
+
+	if _, err := DirFor("bob"); err != ErrUnsupportedPlatform {
+		t.Fatalf("DirFor: expected ErrUnsupportedPlatform got %v", err)
+	}
+	if _, err := DirForAll([]string{"bob"}); err != ErrUnsupportedPlatform {
+		t.Fatalf("DirForAll: expected ErrUnsupportedPlatform got %v", err)
+	}
+	if _, err := InvokingUserDir(); err != ErrUnsupportedPlatform {
+		t.Fatalf("InvokingUserDir: expected ErrUnsupportedPlatform got %v", err)
+	}
+}
+
+func TestDirForms(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	native, posix, err := DirForms()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if native != `C:\Users\bob` || posix != "C:/Users/bob" {
+		t.Fatalf("expected C:\\Users\\bob and C:/Users/bob got %v and %v", native, posix)
+	}
+}
+
+func TestDirHomeShare(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer SetDirEnvChain(nil)
+	defer patchEnv(OverrideEnv, "")()
+	defer patchEnv("USERPROFILE", "")()
+	defer patchEnv("HOMEDRIVE", "")()
+	defer patchEnv("HOME", "")()
+	defer patchEnv("HOMESHARE", `\\fileserver\users`)()
+	defer patchEnv("HOMEPATH", `\bob`)()
+
+	dir, source, err := DirSource()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != `\\fileserver\users\bob` || source != "env:HOMESHARE+HOMEPATH" {
+		t.Fatalf("unexpected home %v (%v)", dir, source)
+	}
+}
+
+func TestExpandDriveAfterTilde(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Drive  bool
+	}{
+		{"~/foo", `C:\Users\bob\foo`, false},
+		{`~\foo`, `C:\Users\bob\foo`, false},
+		{"~", `C:\Users\bob`, false},
+		{"~C:foo", "", true},
+		{`~C:\foo`, "", true},
+		{"~d:/foo", "", true},
+		{"~alice", "", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := Expand(tc.Input)
+		if (err != nil) != (tc.Output == "") {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if err != nil && strings.Contains(err.Error(), "drive") != tc.Drive {
+			t.Fatalf("Input: %#v\n\nunexpected error %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandSafeVolumeRoot(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{`~\x`, `C:\Users\bob\x`},
+		{`~\..`, `C:\Users`},
+		{`~\..\..`, ""},
+		{`~/../../..`, ""},
+		{`C:\`, ""},
+		{`C:`, ""},
+		{`D:/x/..`, ""},
+		{`\\server\share`, ""},
+		{`\\server\share\x`, `\\server\share\x`},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandSafe(tc.Input)
+		if (err != nil) != (tc.Output == "") {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestExpandUserWindows(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	if actual, err := ExpandUser(`~\x`); err != nil || actual != `C:\Users\bob\x` {
+		t.Fatalf("expected C:\\Users\\bob\\x got %q, %v", actual, err)
+	}
+	if _, err := ExpandUser(`~alice\x`); !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+	}
+
+	// The Administrator profile only exists once the account has logged on
+	if actual, err := ExpandUser(`~Administrator\x`); err == nil {
+		if !filepath.IsAbs(actual) || filepath.Base(actual) != "x" {
+			t.Fatalf("unexpected Administrator expansion %q", actual)
+		}
+	}
+}
+
+func TestExpandUNCAfterTilde(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	for _, input := range []string{`~\\server\share`, `~\\server\share\x`, `~//server/share`, `~\\?\C:\x`} {
+		actual, err := Expand(input)
+		if err == nil || !strings.Contains(err.Error(), "UNC") {
+			t.Fatalf("Input: %#v\n\nexpected UNC error, got %q, %v", input, actual, err)
+		}
+	}
+
+	if actual, err := Expand(`~\server\share`); err != nil || actual != `C:\Users\bob\server\share` {
+		t.Fatalf("expected C:\\Users\\bob\\server\\share got %q, %v", actual, err)
+	}
+}
+
+func TestCollapseSlashWindows(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	if actual, err := CollapseSlash(`C:\Users\bob\projects\x`); err != nil || actual != "~/projects/x" {
+		t.Fatalf("expected ~/projects/x got %q, %v", actual, err)
+	}
+	if actual, err := Collapse(`C:\Users\bob\projects\x`); err != nil || actual != `~\projects\x` {
+		t.Fatalf("expected ~\\projects\\x got %q, %v", actual, err)
+	}
+	if actual, err := CollapseSlash(`D:\data\x`); err != nil || actual != "D:/data/x" {
+		t.Fatalf("expected D:/data/x got %q, %v", actual, err)
+	}
+}
+
+func TestExpandLenientWindows(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchHome(`C:\Users\bob`)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{`~\x`, `C:\Users\bob\x`, false},
+		{`~later\x`, `~later\x`, false},
+		{`~{{.Stage}}\x`, `~{{.Stage}}\x`, false},
+		{`~C:foo`, "", true},
+		{`~C:\foo`, "", true},
+		{`~\\server\share\x`, "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandLenient(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package homedir
+
+// KnownFolder returns the location of the named Windows known folder. It is
+// only available on Windows and returns ErrUnsupportedPlatform elsewhere.
+func KnownFolder(id string) (string, error) {
+	return "", ErrUnsupportedPlatform
+}
@@ -0,0 +1,29 @@
+package homedir
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestKnownFolder(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		if _, err := KnownFolder("Documents"); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+		}
+		return
+	}
+
+	for _, id := range []string{"Profile", "Documents", "Desktop", "Downloads"} {
+		dir, err := KnownFolder(id)
+		if err != nil {
+			t.Fatalf("%s: %s", id, err)
+		}
+		if dir == "" {
+			t.Fatalf("%s: empty path", id)
+		}
+	}
+
+	if _, err := KnownFolder("Nonexistent"); err == nil {
+		t.Fatalf("expected error for unknown folder")
+	}
+}
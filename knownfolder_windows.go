@@ -0,0 +1,87 @@
+//go:build windows
+
+package homedir
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modshell32 = syscall.NewLazyDLL("shell32.dll")
+	modole32   = syscall.NewLazyDLL("ole32.dll")
+
+	procSHGetKnownFolderPath = modshell32.NewProc("SHGetKnownFolderPath")
+	procCoTaskMemFree        = modole32.NewProc("CoTaskMemFree")
+)
+
+type guid struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// knownFolderIDs maps the folder names accepted by KnownFolder to their
+// KNOWNFOLDERID values.
+var knownFolderIDs = map[string]guid{
+	// FOLDERID_Profile {5E6C858F-0E22-4760-9AFE-EA3317B67173}
+	"Profile": {0x5E6C858F, 0x0E22, 0x4760, [8]byte{0x9A, 0xFE, 0xEA, 0x33, 0x17, 0xB6, 0x71, 0x73}},
+	// FOLDERID_Desktop {B4BFCC3A-DB2C-424C-B029-7FE99A87C641}
+	"Desktop": {0xB4BFCC3A, 0xDB2C, 0x424C, [8]byte{0xB0, 0x29, 0x7F, 0xE9, 0x9A, 0x87, 0xC6, 0x41}},
+	// FOLDERID_Documents {FDD39AD0-238F-46AF-ADB4-6C85480369C7}
+	"Documents": {0xFDD39AD0, 0x238F, 0x46AF, [8]byte{0xAD, 0xB4, 0x6C, 0x85, 0x48, 0x03, 0x69, 0xC7}},
+	// FOLDERID_Downloads {374DE290-123F-4565-9164-39C4925E467B}
+	"Downloads": {0x374DE290, 0x123F, 0x4565, [8]byte{0x91, 0x64, 0x39, 0xC4, 0x92, 0x5E, 0x46, 0x7B}},
+	// FOLDERID_Music {4BD8D571-6D19-48D3-BE97-422220080E43}
+	"Music": {0x4BD8D571, 0x6D19, 0x48D3, [8]byte{0xBE, 0x97, 0x42, 0x22, 0x20, 0x08, 0x0E, 0x43}},
+	// FOLDERID_Pictures {33E28130-4E1E-4676-835A-98395C3BC3BB}
+	"Pictures": {0x33E28130, 0x4E1E, 0x4676, [8]byte{0x83, 0x5A, 0x98, 0x39, 0x5C, 0x3B, 0xC3, 0xBB}},
+	// FOLDERID_Videos {18989B1D-99B5-455B-841C-AB7C74E4DDFC}
+	"Videos": {0x18989B1D, 0x99B5, 0x455B, [8]byte{0x84, 0x1C, 0xAB, 0x7C, 0x74, 0xE4, 0xDD, 0xFC}},
+	// FOLDERID_RoamingAppData {3EB685DB-65F9-4CF6-A03A-E3EF65729F3D}
+	"RoamingAppData": {0x3EB685DB, 0x65F9, 0x4CF6, [8]byte{0xA0, 0x3A, 0xE3, 0xEF, 0x65, 0x72, 0x9F, 0x3D}},
+	// FOLDERID_LocalAppData {F1B32785-6FBA-4FCF-9D55-7B8E7F157091}
+	"LocalAppData": {0xF1B32785, 0x6FBA, 0x4FCF, [8]byte{0x9D, 0x55, 0x7B, 0x8E, 0x7F, 0x15, 0x70, 0x91}},
+}
+
+// KnownFolder returns the location of the named Windows known folder, such
+// as "Documents", "Desktop" or "Downloads", as reported by
+// SHGetKnownFolderPath. This follows folder redirection, including
+// redirection into OneDrive. The accepted names are Profile, Desktop,
+// Documents, Downloads, Music, Pictures, Videos, RoamingAppData and
+// LocalAppData.
+//
+// ErrUnsupportedPlatform is returned on other operating systems.
+func KnownFolder(id string) (string, error) {
+	folderID, ok := knownFolderIDs[id]
+	if !ok {
+		return "", fmt.Errorf("unknown known folder %q", id)
+	}
+
+	var path *uint16
+	hr, _, _ := procSHGetKnownFolderPath.Call(
+		uintptr(unsafe.Pointer(&folderID)), 0, 0, uintptr(unsafe.Pointer(&path)))
+	// The buffer must be freed even if the call fails.
+	defer procCoTaskMemFree.Call(uintptr(unsafe.Pointer(path)))
+	if hr != 0 {
+		return "", fmt.Errorf("SHGetKnownFolderPath(%s) failed: HRESULT %#x", id, uint32(hr))
+	}
+
+	return utf16PtrToString(path), nil
+}
+
+// utf16PtrToString converts a NUL-terminated UTF-16 string to a string.
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+
+	n := 0
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(p), n*2)) != 0 {
+		n++
+	}
+
+	return syscall.UTF16ToString(unsafe.Slice(p, n))
+}
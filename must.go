@@ -0,0 +1,49 @@
+package homedir
+
+import (
+	"fmt"
+)
+
+// MustDir is like Dir but panics if the home directory cannot be detected.
+func MustDir() string {
+	return must("Dir", Dir)
+}
+
+// MustUser is like User but panics if the user name cannot be detected.
+func MustUser() string {
+	return must("User", User)
+}
+
+// MustExpand is like Expand but panics if path cannot be expanded.
+func MustExpand(path string) string {
+	return must("Expand", func() (string, error) { return Expand(path) })
+}
+
+// MustConfigDir is like ConfigDir but panics if the configuration directory
+// cannot be determined.
+func MustConfigDir() string {
+	return must("ConfigDir", ConfigDir)
+}
+
+// MustCacheDir is like CacheDir but panics if the cache directory cannot be
+// determined.
+func MustCacheDir() string {
+	return must("CacheDir", CacheDir)
+}
+
+// MustDataDir is like DataDir but panics if the data directory cannot be
+// determined.
+func MustDataDir() string {
+	return must("DataDir", DataDir)
+}
+
+// must returns the result of f, panicking with a message naming op if f
+// fails. All Must functions panic with a message of the form
+// "homedir: <op> failed: <err>".
+func must(op string, f func() (string, error)) string {
+	s, err := f()
+	if err != nil {
+		panic(fmt.Sprintf("homedir: %s failed: %v", op, err))
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package homedir
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMust(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	defer patchEnv("USER", "bob")()
+	defer patchEnv("USERNAME", "bob")()
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	defer patchEnv("AppData", home)()
+	defer patchEnv("LocalAppData", home)()
+	defer SetDirEnvChain(nil)
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		return nil, errors.New("no such command")
+	})()
+
+	cases := []struct {
+		Op string
+		F  func() string
+	}{
+		{"Dir", MustDir},
+		{"User", MustUser},
+		{"Expand", func() string { return MustExpand("~/x") }},
+		{"ConfigDir", MustConfigDir},
+		{"CacheDir", MustCacheDir},
+		{"DataDir", MustDataDir},
+	}
+
+	for _, tc := range cases {
+		if actual := tc.F(); actual == "" {
+			t.Fatalf("Must%s returned an empty string", tc.Op)
+		}
+	}
+
+	// Break every getter.
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	patchEnv("USER", "")
+	patchEnv("USERNAME", "")
+	patchEnv("AppData", "")
+	patchEnv("LocalAppData", "")
+	cases[2].F = func() string { return MustExpand("~alice/x") }
+
+	for _, tc := range cases {
+		func() {
+			defer func() {
+				msg, _ := recover().(string)
+				if !strings.HasPrefix(msg, "homedir: "+tc.Op+" failed: ") {
+					t.Fatalf("Must%s: unexpected panic %q", tc.Op, msg)
+				}
+			}()
+			tc.F()
+		}()
+	}
+}
@@ -0,0 +1,74 @@
+package homedir
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// useraddDefaults is the file consulted by DefaultHomeForUser on Linux.
+var useraddDefaults = "/etc/default/useradd"
+
+// DefaultHomeForUser predicts where the operating system would create the
+// home directory of a new user named username, following the platform
+// convention:
+//
+//	Linux    <HOME>/<username>, where HOME is read from
+//	         /etc/default/useradd and defaults to /home
+//	macOS    /Users/<username>
+//	Windows  %SystemDrive%\Users\<username>, with C: if SystemDrive is unset
+//	other    /home/<username>
+//
+// This is a prediction, not a lookup: the user need not exist, and an
+// administrator may still choose a different directory. Use DirFor for
+// existing users.
+func DefaultHomeForUser(username string) (string, error) {
+	if username == "" {
+		return "", errors.New("empty user name")
+	}
+	if strings.ContainsAny(username, `/\`) || username == "." || username == ".." {
+		return "", fmt.Errorf("invalid user name %q", username)
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		drive := os.Getenv("SystemDrive")
+		if drive == "" {
+			drive = "C:"
+		}
+		return filepath.Join(drive+`\`, "Users", username), nil
+	case "darwin":
+		return filepath.Join("/Users", username), nil
+	case "linux":
+		base := "/home"
+		if f, err := os.Open(useraddDefaults); err == nil {
+			if home := parseUseraddHome(f); filepath.IsAbs(home) {
+				base = home
+			}
+			f.Close()
+		}
+		return filepath.Join(base, username), nil
+	}
+
+	return filepath.Join("/home", username), nil
+}
+
+// parseUseraddHome returns the value of HOME in an /etc/default/useradd
+// file, or "" if it isn't set.
+func parseUseraddHome(r io.Reader) string {
+	var home string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "HOME="); ok {
+			home = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+
+	return home
+}
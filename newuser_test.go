@@ -0,0 +1,64 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseUseraddHome(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"# useradd defaults file\nGROUP=100\nHOME=/srv/home\nSHELL=/bin/bash\n", "/srv/home"},
+		{"HOME=\"/export/home\"\n", "/export/home"},
+		{"# HOME=/commented\nSHELL=/bin/sh\n", ""},
+		{"HOME=/first\nHOME=/second\n", "/second"},
+		{"", ""},
+	}
+
+	for _, tc := range cases {
+		actual := parseUseraddHome(strings.NewReader(tc.Input))
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestDefaultHomeForUser(t *testing.T) {
+	defer func(path string) { useraddDefaults = path }(useraddDefaults)
+	useraddDefaults = filepath.Join(t.TempDir(), "useradd")
+	if err := os.WriteFile(useraddDefaults, []byte("HOME=/srv/home\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer patchEnv("SystemDrive", "D:")()
+
+	var expected string
+	switch runtime.GOOS {
+	case "windows":
+		expected = `D:\Users\bob`
+	case "darwin":
+		expected = "/Users/bob"
+	case "linux":
+		expected = "/srv/home/bob"
+	default:
+		expected = "/home/bob"
+	}
+
+	actual, err := DefaultHomeForUser("bob")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual != expected {
+		t.Fatalf("expected %#v got %#v", expected, actual)
+	}
+
+	for _, name := range []string{"", "..", "a/b", `a\b`} {
+		if _, err := DefaultHomeForUser(name); err == nil {
+			t.Fatalf("Input: %#v\n\nexpected an error", name)
+		}
+	}
+}
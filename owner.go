@@ -0,0 +1,43 @@
+package homedir
+
+import "os"
+
+// ExpandForFileOwner is like Expand but a leading `~` stands for the home
+// directory of the user owning file, as looked up by DirForUID, rather than
+// that of the current user. file itself is not followed if it is a symlink,
+// so the owner of the link is used. ErrUnsupportedPlatform is returned on
+// Windows, where file ownership doesn't map to a home directory this way.
+func ExpandForFileOwner(path, file string) (string, error) {
+	if ok, err := hasTilde(path); err != nil {
+		return "", err
+	} else if !ok {
+		return path, nil
+	}
+
+	fi, err := os.Lstat(file)
+	if err != nil {
+		return "", err
+	}
+	uid, err := fileOwner(fi)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := DirForUID(uid)
+	if err != nil {
+		return "", err
+	}
+
+	return ExpandFrom(dir, path)
+}
+
+// OwnsPath reports whether the user running the process owns path, for
+// permission checks before operating on files. Symlinks are followed. On Unix
+// the owning uid is compared with the real uid, and on Windows the owner SID
+// of the file with the user SID of the process token, so a file owned by
+// the Administrators group is not owned by an elevated administrator.
+// ErrUnsupportedPlatform is returned on systems where ownership cannot be
+// determined.
+func OwnsPath(path string) (bool, error) {
+	return ownsPath(path)
+}
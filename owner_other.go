@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package homedir
+
+import "os"
+
+// fileOwner is only implemented on Unix systems.
+func fileOwner(fi os.FileInfo) (int, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+// ownsPath is only implemented on Unix systems and Windows.
+func ownsPath(path string) (bool, error) {
+	return false, ErrUnsupportedPlatform
+}
@@ -0,0 +1,86 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestExpandForFileOwner(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		if _, err := ExpandForFileOwner("~/x", file); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform, got %v", err)
+		}
+		return
+	}
+
+	// The temp file is owned by the effective uid of the test.
+	defer patchRun(getentStub(map[string]string{
+		strconv.Itoa(os.Geteuid()): "owner:x:1234:1234::/home/owner:/bin/sh",
+	}))()
+	defer patchHome("/home/other")()
+
+	cases := []struct {
+		Input  string
+		File   string
+		Output string
+		Err    bool
+	}{
+		{"~", file, "/home/owner", false},
+		{"~/x", file, "/home/owner/x", false},
+		{"/a/b", file, "/a/b", false},
+		{"~/x", file + ".missing", "", true},
+		{"~alice/x", file, "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandForFileOwner(tc.Input, tc.File)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	if _, err := DirForUID(-1); err == nil {
+		t.Fatalf("expected error for uid -1")
+	}
+}
+
+func TestOwnsPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ownership is compared by SID on windows")
+	}
+
+	file := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if owned, err := OwnsPath(file); err != nil || !owned {
+		t.Fatalf("expected to own %s: %v %v", file, owned, err)
+	}
+	if _, err := OwnsPath(file + ".missing"); err == nil {
+		t.Fatalf("expected error for a missing file")
+	}
+
+	// Someone else's file
+	other := "/"
+	if os.Getuid() == 0 {
+		if err := os.Chown(file, 12345, 12345); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		other = file
+	}
+	if owned, err := OwnsPath(other); err != nil || owned {
+		t.Fatalf("expected not to own %s: %v %v", other, owned, err)
+	}
+}
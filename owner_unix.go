@@ -0,0 +1,31 @@
+//go:build unix
+
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid owning the file described by fi.
+func fileOwner(fi os.FileInfo) (int, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("no owner information for %s", fi.Name())
+	}
+	return int(st.Uid), nil
+}
+
+// ownsPath reports whether the real uid owns path.
+func ownsPath(path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	uid, err := fileOwner(fi)
+	if err != nil {
+		return false, err
+	}
+	return uid == os.Getuid(), nil
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modadvapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procGetNamedSecurityInfoW = modadvapi32.NewProc("GetNamedSecurityInfoW")
+)
+
+const (
+	seFileObject             = 1
+	ownerSecurityInformation = 1
+)
+
+// fileOwner is only implemented on Unix systems; Windows files are owned by
+// a SID rather than a uid.
+func fileOwner(fi os.FileInfo) (int, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+// ownsPath reports whether the owner SID of path is the user SID of the
+// current process token.
+func ownsPath(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false, err
+	}
+
+	var owner *syscall.SID
+	var sd uintptr
+	r, _, _ := procGetNamedSecurityInfoW.Call(uintptr(unsafe.Pointer(name)), seFileObject, ownerSecurityInformation,
+		uintptr(unsafe.Pointer(&owner)), 0, 0, 0, uintptr(unsafe.Pointer(&sd)))
+	if r != 0 {
+		return false, fmt.Errorf("cannot read owner of %s: %v", path, syscall.Errno(r))
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	ownerSID, err := owner.String()
+	if err != nil {
+		return false, err
+	}
+	userSID, err := tokenUserSID()
+	if err != nil {
+		return false, err
+	}
+	return ownerSID == userSID, nil
+}
@@ -0,0 +1,251 @@
+package homedir
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Passwd is a parsed passwd entry.
+type Passwd struct {
+	Name  string
+	UID   int
+	GID   int
+	Gecos string
+	Dir   string
+	Shell string
+}
+
+// PasswdEntry returns the passwd entry of the uid Dir() would look up, see
+// SetUseEffectiveUID, as reported by getent. The entry is cached unless
+// DisableCache is set; the returned value is a copy that may be modified.
+// ErrUnsupportedPlatform is returned on Windows.
+func PasswdEntry() (*Passwd, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrUnsupportedPlatform
+	}
+
+	if !DisableCache {
+		cacheLock.RLock()
+		cached := passwdCache
+		cacheLock.RUnlock()
+		if cached != nil {
+			p := *cached
+			return &p, nil
+		}
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	uid := strconv.Itoa(lookupUID())
+	out, err := run(nil, "getent", "passwd", uid)
+	if err != nil {
+		return nil, fmt.Errorf("no passwd entry for uid %s: %v", uid, err)
+	}
+
+	entry, err := ParsePasswdLine(outputString(out))
+	if err != nil {
+		return nil, err
+	}
+
+	passwdCache = entry
+	p := *entry
+	return &p, nil
+}
+
+// ParsePasswdLine parses a single passwd entry of the form
+// name:password:uid:gid:gecos:home:shell. The uid and gid must be numeric
+// and the name non-empty. Since the last two fields are always the home
+// directory and shell, colons in the gecos field are tolerated and kept as
+// part of it.
+func ParsePasswdLine(line string) (*Passwd, error) {
+	parts := strings.Split(line, ":")
+	if len(parts) < 7 {
+		return nil, fmt.Errorf("malformed passwd entry %q: %d fields, want 7", line, len(parts))
+	}
+	if parts[0] == "" {
+		return nil, fmt.Errorf("no user name in passwd entry %q", line)
+	}
+
+	uid, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid in passwd entry %q", line)
+	}
+	gid, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid in passwd entry %q", line)
+	}
+
+	n := len(parts)
+	return &Passwd{
+		Name:  parts[0],
+		UID:   uid,
+		GID:   gid,
+		Gecos: strings.Join(parts[4:n-2], ":"),
+		Dir:   parts[n-2],
+		Shell: parts[n-1],
+	}, nil
+}
+
+// DirForFromPasswd returns the home directory of the named user from the
+// passwd file read from r, for example one inside a backup image, without
+// consulting the system passwd database. Blank lines, comments and lines
+// ParsePasswdLine rejects are skipped. An error is returned if the user has
+// no entry or the entry has no home directory.
+func DirForFromPasswd(r io.Reader, username string) (string, error) {
+	entry, err := scanPasswd(r, func(entry *Passwd) bool {
+		return entry.Name == username
+	})
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", fmt.Errorf("no passwd entry for %q", username)
+	}
+	if entry.Dir == "" {
+		return "", fmt.Errorf("no home directory in passwd entry for %q", username)
+	}
+
+	return entry.Dir, nil
+}
+
+// DirsInRoots returns the home directory of the current user in each of the
+// given root filesystems, such as mounted container images or backups,
+// keyed by root. The home directory is read from <root>/etc/passwd using the
+// uid Dir() would look up; see SetUseEffectiveUID.
+//
+// A root whose passwd file is missing, unreadable or has no entry for the
+// uid is left out of the result, and the reason is included in the returned
+// error, which joins the errors of all such roots. The other roots are
+// still resolved. ErrUnsupportedPlatform is returned on Windows.
+func DirsInRoots(roots []string) (map[string]string, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrUnsupportedPlatform
+	}
+
+	cacheLock.RLock()
+	uid := lookupUID()
+	cacheLock.RUnlock()
+	return DirsInRootsForUID(roots, uid)
+}
+
+// DirsInRootsForUID is like DirsInRoots but looks up the given uid.
+func DirsInRootsForUID(roots []string, uid int) (map[string]string, error) {
+	if uid < 0 {
+		return nil, fmt.Errorf("invalid uid %d", uid)
+	}
+
+	dirs := make(map[string]string, len(roots))
+	var errs []error
+	for _, root := range roots {
+		home, err := rootHome(root, uid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", root, err))
+			continue
+		}
+		dirs[root] = home
+	}
+
+	return dirs, errors.Join(errs...)
+}
+
+// rootHome returns the home directory of uid from root's /etc/passwd.
+func rootHome(root string, uid int) (string, error) {
+	f, err := os.Open(filepath.Join(root, "etc", "passwd"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	entry, err := scanPasswd(f, func(entry *Passwd) bool {
+		return entry.UID == uid
+	})
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", fmt.Errorf("no passwd entry for uid %d", uid)
+	}
+
+	return entry.Dir, nil
+}
+
+// scanPasswd returns the first entry of the passwd file r for which match
+// returns true, or nil if there is none. Blank lines, comments and lines
+// ParsePasswdLine rejects are skipped.
+func scanPasswd(r io.Reader, match func(entry *Passwd) bool) (*Passwd, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		entry, err := ParsePasswdLine(line)
+		if err != nil {
+			continue
+		}
+		if match(entry) {
+			return entry, nil
+		}
+	}
+
+	return nil, scanner.Err()
+}
+
+// parsePwUsershow returns the home directory from the output of
+// `pw usershow -P`, which looks like
+//
+//	Login Name: bob                  #1001            Group: bob          #1001
+//	 Full Name: Bob
+//	      Home: /home/bob            Class:
+//	     Shell: /bin/sh              Office: [None]
+//
+// It returns "" if there is no Home field.
+func parsePwUsershow(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "Home:") {
+			continue
+		}
+
+		home := line[len("Home:"):]
+		if i := strings.Index(home, " Class:"); i >= 0 {
+			home = home[:i]
+		}
+		return strings.TrimSpace(home)
+	}
+
+	return ""
+}
+
+// parseDsclHome returns the home directory from the output of
+// `dscl . -read /Users/<name> NFSHomeDirectory`, which is either
+//
+//	NFSHomeDirectory: /Users/bob
+//
+// or, for long values, the attribute name followed by the value indented on
+// the next line. It returns "" if there is no NFSHomeDirectory attribute.
+func parseDsclHome(out string) string {
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "NFSHomeDirectory:") {
+			continue
+		}
+
+		home := strings.TrimSpace(line[len("NFSHomeDirectory:"):])
+		if home == "" && i+1 < len(lines) {
+			home = strings.TrimSpace(lines[i+1])
+		}
+		return home
+	}
+
+	return ""
+}
@@ -0,0 +1,199 @@
+package homedir
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writePasswd(t *testing.T, root, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(content), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestDirsInRootsForUID(t *testing.T) {
+	tmp := t.TempDir()
+	a := filepath.Join(tmp, "a")
+	b := filepath.Join(tmp, "b")
+	c := filepath.Join(tmp, "c")
+	missing := filepath.Join(tmp, "missing")
+
+	writePasswd(t, a, "root:x:0:0:root:/root:/bin/sh\nbob:x:1000:1000:Bob:/home/bob:/bin/sh\n")
+	writePasswd(t, b, "# comment\nbroken line\nbogus:x:1000x:1000::/bogus:/bin/sh\nbobby:x:1000:1000:Bob: Backup:/data/bobby:/bin/sh\n")
+	writePasswd(t, c, "root:x:0:0:root:/root:/bin/sh\n")
+
+	dirs, err := DirsInRootsForUID([]string{a, b, c, missing}, 1000)
+	expected := map[string]string{a: "/home/bob", b: "/data/bobby"}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("expected %v got %v", expected, dirs)
+	}
+	if err == nil {
+		t.Fatalf("expected errors for %v and %v", c, missing)
+	}
+	for _, root := range []string{c, missing} {
+		if !strings.Contains(err.Error(), root) {
+			t.Fatalf("error does not mention %v: %v", root, err)
+		}
+	}
+
+	dirs, err = DirsInRootsForUID([]string{a, c}, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := map[string]string{a: "/root", c: "/root"}; !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("expected %v got %v", expected, dirs)
+	}
+}
+
+func TestDirForFromPasswd(t *testing.T) {
+	passwd := []byte(`# backup of /etc/passwd
+root:x:0:0:root:/root:/bin/bash
+
+bob:x:1000:1000:Bob,,,:/home/bob:/bin/bash
+broken:x:notanumber:1000::/home/broken:/bin/sh
+alice:x:1001:1001:Alice: Admin:/srv/alice:/bin/zsh
+nohome:x:1002:1002:::/bin/sh
+`)
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"root", "/root", false},
+		{"bob", "/home/bob", false},
+		{"alice", "/srv/alice", false},
+		{"broken", "", true},
+		{"nohome", "", true},
+		{"carol", "", true},
+		{"", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := DirForFromPasswd(bytes.NewReader(passwd), tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestParsePasswdLine(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output *Passwd
+	}{
+		{"bob:x:1000:100:Bob Smith,,,:/home/bob:/bin/zsh",
+			&Passwd{"bob", 1000, 100, "Bob Smith,,,", "/home/bob", "/bin/zsh"}},
+		{"svc:*:998:998:::", &Passwd{"svc", 998, 998, "", "", ""}},
+		{"", nil},
+		{"bob:x:1000:100:Bob:/home/bob", nil},
+		{"bob:x:1000:100:Bob: Room 1:Ext 2:/home/bob:/bin/sh",
+			&Passwd{"bob", 1000, 100, "Bob: Room 1:Ext 2", "/home/bob", "/bin/sh"}},
+		{"bob:x:1000:100:Bob", nil},
+		{"bob", nil},
+		{":x:1000:100:Bob:/home/bob:/bin/sh", nil},
+		{"bob:x:abc:100:Bob:/home/bob:/bin/sh", nil},
+		{"bob:x:1000::Bob:/home/bob:/bin/sh", nil},
+	}
+
+	for _, tc := range cases {
+		actual, err := ParsePasswdLine(tc.Input)
+		if (err != nil) != (tc.Output == nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if !reflect.DeepEqual(actual, tc.Output) {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestPasswdEntry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		if _, err := PasswdEntry(); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform, got %v", err)
+		}
+		return
+	}
+
+	Reset()
+	defer Reset()
+	uid := strconv.Itoa(os.Getuid())
+	calls := 0
+	defer patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		calls++
+		return getentStub(map[string]string{
+			uid: "bob:x:" + uid + ":100:Bob:/home/bob:/bin/sh",
+		})(env, name, arg...)
+	})()
+
+	for i := 0; i < 2; i++ {
+		entry, err := PasswdEntry()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if entry.Name != "bob" || entry.Dir != "/home/bob" || entry.Shell != "/bin/sh" {
+			t.Fatalf("unexpected entry %#v", entry)
+		}
+		entry.Name = "mutated"
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single getent call, got %d", calls)
+	}
+}
+
+func TestParsePwUsershow(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"Login Name: bob                  #1001            Group: bob                #1001\n" +
+			" Full Name: Bob\n" +
+			"      Home: /home/bob            Class: \n" +
+			"     Shell: /bin/sh              Office: [None]\n" +
+			"Work Phone: [None]          Home Phone: [None]\n" +
+			"Acc Expire: [None]          Pwd Expire: [None]\n", "/home/bob"},
+		{"      Home: /usr/home/alice/with a space Class: staff\n", "/usr/home/alice/with a space"},
+		{"      Home: /home/carol\n", "/home/carol"},
+		{"Work Phone: [None]          Home Phone: [None]\n", ""},
+		{"", ""},
+	}
+
+	for _, tc := range cases {
+		actual := parsePwUsershow(tc.Input)
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
+
+func TestParseDsclHome(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"NFSHomeDirectory: /Users/bob\n", "/Users/bob"},
+		{"NFSHomeDirectory:\n /Users/alice with a very long name\n", "/Users/alice with a very long name"},
+		{"No such key: NFSHomeDirectory\n", ""},
+		{"", ""},
+	}
+
+	for _, tc := range cases {
+		actual := parseDsclHome(tc.Input)
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
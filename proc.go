@@ -0,0 +1,81 @@
+package homedir
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where DirForPID looks for process information. It is a
+// variable so that tests can substitute a fake /proc.
+var procRoot = "/proc"
+
+// DirForPID returns the home directory that the process pid would resolve
+// `~` to. HOME is read from /proc/<pid>/environ; if it is unset, not
+// absolute or the environment cannot be read, which is usually the case for
+// processes of other users, the home directory of the process's uid from
+// /proc/<pid>/status is looked up with DirForUID. The real uid is used
+// unless SetUseEffectiveUID selected the effective one.
+//
+// The environment of a process is the one it started with, so changes it
+// made to HOME at run time are not seen. ErrUnsupportedPlatform is returned
+// on systems other than Linux.
+func DirForPID(pid int) (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", ErrUnsupportedPlatform
+	}
+	if pid <= 0 {
+		return "", fmt.Errorf("invalid pid %d", pid)
+	}
+
+	cacheLock.RLock()
+	log, effective := logger, useEffectiveUID
+	cacheLock.RUnlock()
+
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	if environ, err := os.ReadFile(filepath.Join(dir, "environ")); err == nil {
+		for _, kv := range bytes.Split(environ, []byte{0}) {
+			if home := string(bytes.TrimPrefix(kv, []byte("HOME="))); len(home) < len(kv) && filepath.IsAbs(home) {
+				return filepath.Clean(home), nil
+			}
+		}
+	} else if log != nil {
+		log("cannot read process environment", "pid", pid, "err", err)
+	}
+
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return "", err
+	}
+	uid, err := parseStatusUID(string(status), effective)
+	if err != nil {
+		return "", fmt.Errorf("process %d: %v", pid, err)
+	}
+
+	return DirForUID(uid)
+}
+
+// parseStatusUID returns the real uid, or the effective one if effective is
+// set, from the contents of /proc/<pid>/status.
+func parseStatusUID(status string, effective bool) (int, error) {
+	for _, line := range strings.Split(status, "\n") {
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		fields := strings.Fields(line[len("Uid:"):])
+		i := 0
+		if effective {
+			i = 1
+		}
+		if len(fields) <= i {
+			break
+		}
+		return strconv.Atoi(fields[i])
+	}
+
+	return 0, fmt.Errorf("no uid in status")
+}
@@ -0,0 +1,84 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDirForPID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := DirForPID(1); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+		}
+		return
+	}
+
+	defer Snapshot()()
+	procRoot = t.TempDir()
+	defer patchRun(getentStub(map[string]string{
+		"1001": "alice:x:1001:1001:Alice:/home/alice:/bin/sh",
+		"1002": "bob:x:1002:1002:Bob:/home/bob:/bin/sh",
+	}))()
+
+	status := "Name:\tdaemon\nUid:\t1001\t1002\t1002\t1002\nGid:\t100\t100\t100\t100\n"
+	procs := map[string]map[string]string{
+		"10": {"environ": "PATH=/bin\x00HOME=/home/target/\x00", "status": status},
+		"11": {"environ": "PATH=/bin\x00", "status": status},
+		"12": {"environ": "HOME=~root\x00", "status": status},
+		"13": {"status": status},
+		"14": {"environ": "", "status": "Name:\tdaemon\n"},
+	}
+	for pid, files := range procs {
+		dir := filepath.Join(procRoot, pid)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+	}
+
+	cases := []struct {
+		PID       int
+		Effective bool
+		Output    string
+		Err       bool
+	}{
+		{10, false, "/home/target", false},
+		{11, false, "/home/alice", false},
+		{11, true, "/home/bob", false},
+		{12, false, "/home/alice", false},
+		{13, false, "/home/alice", false},
+		{14, false, "", true},
+		{15, false, "", true},
+		{0, false, "", true},
+	}
+
+	for _, tc := range cases {
+		SetUseEffectiveUID(tc.Effective)
+		actual, err := DirForPID(tc.PID)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc, actual)
+		}
+	}
+
+	// Changing the logger concurrently is safe, see go test -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetLogger(func(string, ...interface{}) {})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		DirForPID(13)
+	}
+	<-done
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package homedir
+
+// profileDir is only implemented on Windows, where DirFor has no passwd
+// database to consult.
+func profileDir(username string) (string, error) {
+	return "", ErrUnsupportedPlatform
+}
@@ -0,0 +1,88 @@
+//go:build windows
+
+package homedir
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procExpandEnvironmentStringsW = modkernel32.NewProc("ExpandEnvironmentStringsW")
+)
+
+// errNoMoreItems is ERROR_NO_MORE_ITEMS, returned once every subkey of a
+// registry key has been enumerated.
+const errNoMoreItems syscall.Errno = 259
+
+// profileListKey is the registry key holding one subkey per local profile,
+// named after the SID of its account.
+const profileListKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\ProfileList`
+
+// profileDir returns the profile directory of the named account from the
+// profile list. Only the built-in Administrator account, whose SID always
+// ends in the relative ID 500, can be identified there by name.
+func profileDir(username string) (string, error) {
+	if !strings.EqualFold(username, "Administrator") {
+		return "", fmt.Errorf("%w %q: %w", ErrUnknownUser, username, ErrUnsupportedPlatform)
+	}
+
+	var list syscall.Handle
+	err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, syscall.StringToUTF16Ptr(profileListKey), 0, syscall.KEY_READ, &list)
+	if err != nil {
+		return "", fmt.Errorf("cannot open profile list: %v", err)
+	}
+	defer syscall.RegCloseKey(list)
+
+	for i := uint32(0); ; i++ {
+		name := make([]uint16, 256)
+		n := uint32(len(name))
+		err := syscall.RegEnumKeyEx(list, i, &name[0], &n, nil, nil, nil, nil)
+		if err == errNoMoreItems {
+			return "", fmt.Errorf("%w: no profile for %q", ErrUnknownUser, username)
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot read profile list: %v", err)
+		}
+
+		sid := syscall.UTF16ToString(name[:n])
+		if strings.HasPrefix(sid, "S-1-5-21-") && strings.HasSuffix(sid, "-500") {
+			return profileImagePath(list, sid)
+		}
+	}
+}
+
+// profileImagePath returns the expanded ProfileImagePath value of the
+// profile list entry for sid.
+func profileImagePath(list syscall.Handle, sid string) (string, error) {
+	var key syscall.Handle
+	if err := syscall.RegOpenKeyEx(list, syscall.StringToUTF16Ptr(sid), 0, syscall.KEY_READ, &key); err != nil {
+		return "", fmt.Errorf("cannot open profile %s: %v", sid, err)
+	}
+	defer syscall.RegCloseKey(key)
+
+	var typ uint32
+	buf := make([]uint16, syscall.MAX_PATH)
+	n := uint32(len(buf) * 2)
+	err := syscall.RegQueryValueEx(key, syscall.StringToUTF16Ptr("ProfileImagePath"), nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &n)
+	if err != nil {
+		return "", fmt.Errorf("no profile path for %s: %v", sid, err)
+	}
+	path := syscall.UTF16ToString(buf[:n/2])
+	if typ != syscall.REG_EXPAND_SZ {
+		return path, nil
+	}
+
+	src := syscall.StringToUTF16Ptr(path)
+	expanded := make([]uint16, syscall.MAX_PATH)
+	r, _, e := procExpandEnvironmentStringsW.Call(uintptr(unsafe.Pointer(src)),
+		uintptr(unsafe.Pointer(&expanded[0])), uintptr(len(expanded)))
+	if r == 0 || int(r) > len(expanded) {
+		return "", fmt.Errorf("cannot expand profile path %q: %v", path, e)
+	}
+	return syscall.UTF16ToString(expanded), nil
+}
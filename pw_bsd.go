@@ -0,0 +1,19 @@
+//go:build freebsd || dragonfly
+
+package homedir
+
+import "strconv"
+
+// pwHome returns the home directory of the current user as reported by
+// `pw usershow -P`, or "" if it cannot be determined.
+//
+// OpenBSD and NetBSD are deliberately not included: they have no pw(8), and
+// their userinfo(8) reads the same passwd database getent already consulted.
+func pwHome() string {
+	out, err := run(nil, "pw", "usershow", "-P", "-u", strconv.Itoa(lookupUID()))
+	if err != nil {
+		return ""
+	}
+
+	return parsePwUsershow(outputString(out))
+}
@@ -0,0 +1,9 @@
+//go:build !freebsd && !dragonfly
+
+package homedir
+
+// pwHome is only implemented on FreeBSD and DragonFly, where pw(8) exists.
+// Other BSDs, including OpenBSD, have no pw(8) and rely on getent.
+func pwHome() string {
+	return ""
+}
@@ -0,0 +1,47 @@
+package homedir
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ReloadOnSignal installs a handler that calls Reset whenever one of the
+// given signals arrives, so that a long-running daemon can be told to
+// re-resolve the home directory and user name after an administrator has
+// changed the environment or the passwd database. Without signals it
+// defaults to SIGHUP on Unix systems; elsewhere nothing is installed. The
+// returned function uninstalls the handler and may be called more than once.
+func ReloadOnSignal(sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = reloadSignals
+	}
+	if len(sig) == 0 {
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-ch:
+				Reset()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+			<-finished
+		})
+	}
+}
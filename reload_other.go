@@ -0,0 +1,8 @@
+//go:build !unix
+
+package homedir
+
+import "os"
+
+// reloadSignals is empty where there is no conventional reload signal.
+var reloadSignals []os.Signal
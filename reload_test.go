@@ -0,0 +1,56 @@
+//go:build unix
+
+package homedir
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	defer Snapshot()()
+
+	cached := func() bool {
+		cacheLock.RLock()
+		defer cacheLock.RUnlock()
+		return homedirCache != ""
+	}
+	fill := func() {
+		cacheLock.Lock()
+		defer cacheLock.Unlock()
+		homedirCache = "/home/stale"
+		userCache = "stale"
+	}
+
+	stop := ReloadOnSignal()
+	fill()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cached() {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache not cleared after SIGHUP")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stop()
+
+	// Explicit signals replace the default
+	stop = ReloadOnSignal(syscall.SIGUSR1)
+	defer stop()
+	fill()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for cached() {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache not cleared after SIGUSR1")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package homedir
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals ReloadOnSignal handles by default.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,24 @@
+//go:build go1.24
+
+package homedir
+
+import (
+	"os"
+)
+
+// OpenRoot opens the home directory as an *os.Root, which confines all file
+// access through it to the home directory: names such as "../etc/passwd" or
+// symlinks pointing outside of it are rejected. Use ExpandFS to turn tilde
+// paths into names relative to the root.
+//
+// Errors resolving the home directory are returned as-is, while errors
+// opening it are the *fs.PathError of os.OpenRoot. OpenRoot requires Go 1.24
+// or later.
+func OpenRoot() (*os.Root, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenRoot(dir)
+}
@@ -0,0 +1,48 @@
+//go:build go1.24
+
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenRoot(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	defer patchHome(home)()
+
+	if _, err := OpenRoot(); err == nil {
+		t.Fatalf("expected error for missing home")
+	}
+
+	if err := os.MkdirAll(home, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "inside"), []byte("x"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "outside"), []byte("x"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	root, err := OpenRoot()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer root.Close()
+
+	f, err := root.Open("inside")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	f.Close()
+
+	if f, err := root.Open("../outside"); err == nil {
+		f.Close()
+		t.Fatalf("expected ../outside to be rejected")
+	}
+}
@@ -0,0 +1,35 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// HostDir returns the home directory of the user on the host system when
+// running inside a Linux desktop sandbox, and Dir() otherwise.
+//
+// A Snap sandbox is detected by $SNAP being set. Snap points $HOME at a
+// per-snap directory such as ~/snap/<name>/<revision> and exports the real
+// home as $SNAP_REAL_HOME, which is returned.
+//
+// A Flatpak sandbox is detected by $FLATPAK_ID being set or $container being
+// "flatpak". Flatpak normally leaves $HOME pointing at the host home and
+// redirects the XDG directories into ~/.var/app instead, so $HOST_HOME is
+// used when set and Dir() otherwise.
+//
+// Only absolute values are used; a blank or relative host variable falls
+// back to Dir().
+func HostDir() (string, error) {
+	var host string
+	switch {
+	case os.Getenv("SNAP") != "":
+		host = os.Getenv("SNAP_REAL_HOME")
+	case os.Getenv("FLATPAK_ID") != "" || os.Getenv("container") == "flatpak":
+		host = os.Getenv("HOST_HOME")
+	}
+
+	if filepath.IsAbs(host) {
+		return filepath.Clean(host), nil
+	}
+	return Dir()
+}
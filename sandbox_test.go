@@ -0,0 +1,49 @@
+package homedir
+
+import "testing"
+
+func TestHostDir(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob/snap/app/42")
+	defer patchHome(home)()
+	defer patchEnv("SNAP", "")()
+	defer patchEnv("SNAP_REAL_HOME", "")()
+	defer patchEnv("FLATPAK_ID", "")()
+	defer patchEnv("container", "")()
+	defer patchEnv("HOST_HOME", "")()
+
+	host := nativePath("/home/bob")
+	cases := []struct {
+		Env    map[string]string
+		Output string
+	}{
+		{nil, home},
+		{map[string]string{"SNAP_REAL_HOME": host}, home},
+		{map[string]string{"SNAP": "/snap/app/42", "SNAP_REAL_HOME": host}, host},
+		{map[string]string{"SNAP": "/snap/app/42", "SNAP_REAL_HOME": "bob"}, home},
+		{map[string]string{"SNAP": "/snap/app/42"}, home},
+		{map[string]string{"FLATPAK_ID": "org.example.App", "HOST_HOME": host}, host},
+		{map[string]string{"container": "flatpak", "HOST_HOME": host}, host},
+		{map[string]string{"container": "docker", "HOST_HOME": host}, home},
+		{map[string]string{"FLATPAK_ID": "org.example.App"}, home},
+	}
+
+	for _, tc := range cases {
+		var restore []func()
+		for k, v := range tc.Env {
+			restore = append(restore, patchEnv(k, v))
+		}
+
+		actual, err := HostDir()
+		for _, f := range restore {
+			f()
+		}
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Env, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Env, actual)
+		}
+	}
+}
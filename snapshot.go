@@ -0,0 +1,177 @@
+package homedir
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Snapshot captures all mutable package state — the exported variables such
+// as DisableCache, the caches, and every option changed through the Set*
+// functions — and returns a function that restores it. It is intended for
+// tests that change the package configuration:
+//
+//	defer homedir.Snapshot()()
+func Snapshot() func() {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+
+	s := state{
+		disableCache:     DisableCache,
+		rejectRootHome:   RejectRootHome,
+		allowJoinEscape:  AllowJoinEscape,
+		requireTag:       RequireTag,
+		homesEnv:         HomesEnv,
+		defaultDir:       DefaultDir,
+		homedirCache:     homedirCache,
+		homedirSource:    homedirSource,
+		userCache:        userCache,
+		userSource:       userSource,
+		userDirCache:     copyMap(userDirCache),
+		userDirOrder:     append([]string(nil), userDirCacheOrder...),
+		qualifiedUser:    copyMap(qualifiedUserCache),
+		expandCache:      copyMap(expandCache),
+		expandCacheOrder: append([]string(nil), expandCacheOrder...),
+		maxCacheEntries:  maxCacheEntries,
+		dirEnvChain:      dirEnvChain,
+		userMethodOrder:  userMethodOrder,
+		useEffectiveUID:  useEffectiveUID,
+		whoamiBypass:     whoamiBypass,
+		postProcessor:    expandPostProcessor,
+		passwdCache:      passwdCache,
+		homeTemplate:     homeTemplate,
+		shellFallbackEnv: shellFallbackEnv,
+		strategy:         discoveryStrategy,
+		unusableHomes:    unusableHomes,
+		minUID:           minUID,
+		crossPlatformEnv: crossPlatformEnv,
+		preferStdlib:     preferStdlib,
+		stdlibHomeDir:    stdlibHomeDir,
+		procRoot:         procRoot,
+		useraddDefaults:  useraddDefaults,
+		logger:           logger,
+		cacheTTL:         cacheTTL,
+		homedirCachedAt:  homedirCachedAt,
+		userCachedAt:     userCachedAt,
+		userDirCachedAt:  copyTimes(userDirCachedAt),
+		userDirUsedAt:    copyTimes(userDirUsedAt),
+		idleTimeout:      cacheIdleTimeout,
+		now:              now,
+		run:              run,
+		runStream:        runStream,
+		platform:         snapshotPlatform(),
+	}
+	return s.restore
+}
+
+// state holds the package state captured by Snapshot.
+type state struct {
+	disableCache     bool
+	rejectRootHome   bool
+	allowJoinEscape  bool
+	requireTag       bool
+	homesEnv         string
+	defaultDir       string
+	homedirCache     string
+	homedirSource    string
+	userCache        string
+	userSource       string
+	userDirCache     map[string]string
+	userDirOrder     []string
+	qualifiedUser    map[string]string
+	expandCache      map[string]string
+	expandCacheOrder []string
+	maxCacheEntries  int
+	dirEnvChain      []string
+	userMethodOrder  []string
+	useEffectiveUID  bool
+	whoamiBypass     bool
+	postProcessor    func(string) string
+	passwdCache      *Passwd
+	homeTemplate     string
+	shellFallbackEnv []string
+	strategy         string
+	unusableHomes    []string
+	minUID           int
+	crossPlatformEnv bool
+	preferStdlib     bool
+	stdlibHomeDir    func() (string, error)
+	procRoot         string
+	useraddDefaults  string
+	logger           func(msg string, keyvals ...interface{})
+	cacheTTL         time.Duration
+	homedirCachedAt  time.Time
+	userCachedAt     time.Time
+	userDirCachedAt  map[string]time.Time
+	userDirUsedAt    map[string]time.Time
+	idleTimeout      time.Duration
+	now              func() time.Time
+	run              func(env []string, name string, arg ...string) ([]byte, error)
+	runStream        func(ctx context.Context, name string, arg ...string) (io.Reader, func() error, error)
+	platform         platformState
+}
+
+func (s state) restore() {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	DisableCache = s.disableCache
+	RejectRootHome = s.rejectRootHome
+	AllowJoinEscape = s.allowJoinEscape
+	RequireTag = s.requireTag
+	HomesEnv = s.homesEnv
+	DefaultDir = s.defaultDir
+	homedirCache = s.homedirCache
+	homedirSource = s.homedirSource
+	userCache = s.userCache
+	userSource = s.userSource
+	userDirCache = copyMap(s.userDirCache)
+	userDirCacheOrder = append([]string(nil), s.userDirOrder...)
+	qualifiedUserCache = copyMap(s.qualifiedUser)
+	expandCache = copyMap(s.expandCache)
+	expandCacheOrder = append([]string(nil), s.expandCacheOrder...)
+	maxCacheEntries = s.maxCacheEntries
+	dirEnvChain = s.dirEnvChain
+	userMethodOrder = s.userMethodOrder
+	useEffectiveUID = s.useEffectiveUID
+	whoamiBypass = s.whoamiBypass
+	expandPostProcessor = s.postProcessor
+	passwdCache = s.passwdCache
+	homeTemplate = s.homeTemplate
+	shellFallbackEnv = s.shellFallbackEnv
+	discoveryStrategy = s.strategy
+	unusableHomes = s.unusableHomes
+	minUID = s.minUID
+	crossPlatformEnv = s.crossPlatformEnv
+	preferStdlib = s.preferStdlib
+	stdlibHomeDir = s.stdlibHomeDir
+	procRoot = s.procRoot
+	useraddDefaults = s.useraddDefaults
+	logger = s.logger
+	cacheTTL = s.cacheTTL
+	homedirCachedAt = s.homedirCachedAt
+	userCachedAt = s.userCachedAt
+	userDirCachedAt = copyTimes(s.userDirCachedAt)
+	userDirUsedAt = copyTimes(s.userDirUsedAt)
+	cacheIdleTimeout = s.idleTimeout
+	now = s.now
+	run = s.run
+	runStream = s.runStream
+	s.platform.restore()
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func copyTimes(m map[string]time.Time) map[string]time.Time {
+	c := make(map[string]time.Time, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package homedir
+
+// platformState holds the platform-specific package state captured by
+// Snapshot, of which there is none outside Windows.
+type platformState struct{}
+
+func snapshotPlatform() platformState {
+	return platformState{}
+}
+
+func (p platformState) restore() {}
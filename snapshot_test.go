@@ -0,0 +1,50 @@
+package homedir
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	defer Reset()
+	Reset()
+	x := nativePath("/x")
+	homedirCache = x
+	userDirCache["bob"] = nativePath("/home/bob")
+
+	restore := Snapshot()
+	DisableCache = true
+	RejectRootHome = true
+	AllowJoinEscape = true
+	HomesEnv = "OTHER_HOMES"
+	DefaultDir = nativePath("/build")
+	SetDirEnvChain([]string{"APP_HOME"})
+	SetUseEffectiveUID(true)
+	SetHomeTemplate("/home/{user}")
+	if err := SetUserMethodOrder([]string{"id"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	SetExpandPostProcessor(func(path string) string { return path })
+	userDirCache["alice"] = nativePath("/home/alice")
+	patchRun(nil)
+	useraddDefaults = nativePath("/tmp/useradd")
+	restore()
+
+	if DisableCache || RejectRootHome || AllowJoinEscape || HomesEnv != "HOMES" || DefaultDir != "" {
+		t.Fatalf("exported variables not restored")
+	}
+	if !reflect.DeepEqual(dirEnvChain, defaultDirEnvChain()) ||
+		!reflect.DeepEqual(userMethodOrder, defaultUserMethodOrder) ||
+		useEffectiveUID || expandPostProcessor != nil || homeTemplate != "" {
+		t.Fatalf("options not restored")
+	}
+	if homedirCache != x || len(userDirCache) != 1 {
+		t.Fatalf("caches not restored: %v %v", homedirCache, userDirCache)
+	}
+	if run == nil {
+		t.Fatalf("runner not restored")
+	}
+	if useraddDefaults != "/etc/default/useradd" {
+		t.Fatalf("useradd defaults not restored: %v", useraddDefaults)
+	}
+}
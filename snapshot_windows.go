@@ -0,0 +1,21 @@
+//go:build windows
+
+package homedir
+
+// platformState holds the Windows-only package state captured by Snapshot.
+type platformState struct {
+	tokenProfileDir func() (string, error)
+	tokenUserSID    func() (string, error)
+}
+
+func snapshotPlatform() platformState {
+	return platformState{
+		tokenProfileDir: tokenProfileDir,
+		tokenUserSID:    tokenUserSID,
+	}
+}
+
+func (p platformState) restore() {
+	tokenProfileDir = p.tokenProfileDir
+	tokenUserSID = p.tokenUserSID
+}
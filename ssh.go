@@ -0,0 +1,48 @@
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// SSHDir returns the directory of the user's OpenSSH configuration and
+// keys, ~/.ssh. On Windows it is %USERPROFILE%\.ssh, which is where the
+// OpenSSH port looks regardless of the home directory chain, see
+// SetDirEnvChain, unless the home directory is pinned, see OverrideEnv. The
+// directory is not checked or created; see SSHDirEnsure.
+func SSHDir() (string, error) {
+	if runtime.GOOS == "windows" && !homePinned() {
+		if profile := os.Getenv("USERPROFILE"); filepath.IsAbs(profile) {
+			return filepath.Join(profile, ".ssh"), nil
+		}
+	}
+
+	return homeJoin(".ssh")
+}
+
+// SSHDirEnsure is like SSHDir but also creates the directory with
+// permissions 0700 if it doesn't exist. On Unix systems an existing
+// directory that is accessible to the group or others is reported as an
+// error rather than changed, since OpenSSH refuses to use keys in it.
+func SSHDirEnsure() (string, error) {
+	dir, err := SSHDir()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create ssh directory: %v", err)
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("ssh directory %s has insecure permissions %v, expected 0700", dir, fi.Mode().Perm())
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,55 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSSHDir(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	dir, err := SSHDir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(home, ".ssh"); dir != expected {
+		t.Fatalf("expected %v got %v", expected, dir)
+	}
+}
+
+func TestSSHDirEnsure(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	dir, err := SSHDirEnsure()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(home, ".ssh"); dir != expected {
+		t.Fatalf("expected %v got %v", expected, dir)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected mode 0700 got %v", fi.Mode().Perm())
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := SSHDirEnsure(); err == nil {
+		t.Fatalf("expected error for an insecure ssh directory")
+	}
+}
@@ -0,0 +1,90 @@
+package homedir
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ExpandStruct expands, in place, the fields of the struct pointed to by v
+// that are tagged `homedir:"expand"`. Supported field kinds are string and
+// []string, whose every element is expanded. Exported fields holding a
+// struct or a non-nil pointer to a struct are walked recursively whether or
+// not they are tagged. A struct reached through several pointers, including
+// a pointer back to itself, is only walked once. Untagged string fields and
+// unexported fields are left untouched.
+//
+// An error naming the field is returned for a tagged field of any other kind
+// and for a value that fails to expand; fields processed before it keep
+// their expanded values.
+func ExpandStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("ExpandStruct requires a non-nil pointer to a struct")
+	}
+
+	seen := map[visit]bool{{rv.Pointer(), rv.Elem().Type()}: true}
+	return expandStruct(rv.Elem(), rv.Elem().Type().Name(), seen)
+}
+
+// visit identifies a struct reached through a pointer. The type is part of
+// it because a struct and its first field share an address.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// expandStruct expands the tagged fields of the struct value rv. prefix is
+// used to name fields in errors. seen records the structs already walked
+// through pointers.
+func expandStruct(rv reflect.Value, prefix string, seen map[visit]bool) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + "." + field.Name
+		fv := rv.Field(i)
+
+		if fv.Kind() == reflect.Pointer && !fv.IsNil() && fv.Elem().Kind() == reflect.Struct {
+			key := visit{fv.Pointer(), fv.Elem().Type()}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct {
+			if err := expandStruct(fv, name, seen); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if field.Tag.Get("homedir") != "expand" {
+			continue
+		}
+
+		switch {
+		case fv.Kind() == reflect.String:
+			expanded, err := Expand(fv.String())
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fv.SetString(expanded)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+			for j := 0; j < fv.Len(); j++ {
+				expanded, err := Expand(fv.Index(j).String())
+				if err != nil {
+					return fmt.Errorf("%s[%d]: %v", name, j, err)
+				}
+				fv.Index(j).SetString(expanded)
+			}
+		default:
+			return fmt.Errorf("%s: cannot expand field of type %s", name, fv.Type())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package homedir
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandStruct(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	type Cache struct {
+		Dir  string `homedir:"expand"`
+		Name string
+	}
+	type Config struct {
+		Log     string   `homedir:"expand"`
+		Plugins []string `homedir:"expand"`
+		Raw     string
+		Cache   Cache
+		Backup  *Cache
+		Missing *Cache
+		secret  string `homedir:"expand"`
+	}
+
+	cfg := Config{
+		Log:     "~/app.log",
+		Plugins: []string{"~/plugins", "/opt/plugins"},
+		Raw:     "~/raw",
+		Cache:   Cache{Dir: "~/.cache", Name: "~/name"},
+		Backup:  &Cache{Dir: "~/backup"},
+		secret:  "~/secret",
+	}
+	if err := ExpandStruct(&cfg); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Config{
+		Log:     filepath.Join(home, "app.log"),
+		Plugins: []string{filepath.Join(home, "plugins"), "/opt/plugins"},
+		Raw:     "~/raw",
+		Cache:   Cache{Dir: filepath.Join(home, ".cache"), Name: "~/name"},
+		Backup:  &Cache{Dir: filepath.Join(home, "backup")},
+		secret:  "~/secret",
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("expected %#v got %#v", expected, cfg)
+	}
+
+	var bad struct {
+		Port int `homedir:"expand"`
+	}
+	if err := ExpandStruct(&bad); err == nil {
+		t.Fatalf("expected error for int field")
+	}
+
+	var user struct {
+		Dir string `homedir:"expand"`
+	}
+	user.Dir = "~alice/x"
+	if err := ExpandStruct(&user); err == nil {
+		t.Fatalf("expected error for ~alice/x")
+	}
+
+	if err := ExpandStruct(cfg); err == nil {
+		t.Fatalf("expected error for non-pointer")
+	}
+
+	// Self-referential structs are walked once
+	type Node struct {
+		Dir  string `homedir:"expand"`
+		Next *Node
+	}
+	a := &Node{Dir: "~/a"}
+	b := &Node{Dir: "~/b", Next: a}
+	a.Next = b
+	if err := ExpandStruct(a); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if a.Dir != filepath.Join(home, "a") || b.Dir != filepath.Join(home, "b") {
+		t.Fatalf("expected expanded nodes got %#v, %#v", a.Dir, b.Dir)
+	}
+}
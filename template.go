@@ -0,0 +1,62 @@
+package homedir
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the dot of the templates executed by ExpandTemplateString.
+// Its methods are only called, and the directories only resolved, when a
+// template refers to them. Vars holds the data passed to
+// ExpandTemplateString.
+type TemplateData struct {
+	Vars interface{}
+}
+
+// Home returns the home directory, see Dir.
+func (TemplateData) Home() (string, error) {
+	return Dir()
+}
+
+// User returns the executing user name, see User.
+func (TemplateData) User() (string, error) {
+	return User()
+}
+
+// Config returns the configuration directory, see ConfigDir.
+func (TemplateData) Config() (string, error) {
+	return ConfigDir()
+}
+
+// Cache returns the cache directory, see CacheDir.
+func (TemplateData) Cache() (string, error) {
+	return CacheDir()
+}
+
+// Data returns the data directory, see DataDir.
+func (TemplateData) Data() (string, error) {
+	return DataDir()
+}
+
+// ExpandTemplateString executes path as a text/template whose dot is a
+// TemplateData, then expands a leading `~` of the result like Expand:
+//
+//	homedir.ExpandTemplateString("{{.Config}}/app/{{.Vars.Profile}}.toml", cfg)
+//
+// data is available to the template as .Vars. Errors parsing or executing
+// the template, including a directory that cannot be resolved, are
+// reported for path.
+func ExpandTemplateString(path string, data interface{}) (string, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand %q: %v", path, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, TemplateData{Vars: data}); err != nil {
+		return "", fmt.Errorf("cannot expand %q: %v", path, err)
+	}
+
+	return Expand(b.String())
+}
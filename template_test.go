@@ -0,0 +1,59 @@
+package homedir
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandTemplateString(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	home := nativePath("/home/bob")
+	config := nativePath("/xdg/config")
+	defer patchHome(home)()
+	defer patchEnv("USER", "bob")()
+	defer patchEnv("USERNAME", "bob")()
+	defer patchEnv("XDG_CONFIG_HOME", config)()
+	defer patchEnv("AppData", config)()
+	if runtime.GOOS == "darwin" {
+		config = filepath.Join(home, "Library", "Application Support")
+	}
+
+	vars := map[string]string{"Profile": "work"}
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"{{.Home}}/x", home + "/x", false},
+		{"{{.Config}}/app", config + "/app", false},
+		{"{{.Config}}/app/{{.Vars.Profile}}.toml", config + "/app/work.toml", false},
+		{"~/{{.User}}", filepath.Join(home, "bob"), false},
+		{"/plain/path", "/plain/path", false},
+		{"{{.Vars.Missing}}", "", true},
+		{"{{.Nope}}", "", true},
+		{"{{.Home", "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandTemplateString(tc.Input, vars)
+		if tc.Err != (err != nil) {
+			t.Fatalf("Input: %#v\n\nErr: %v", tc.Input, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+
+	// Directories that aren't referred to are not resolved
+	SetDirEnvChain([]string{"HOMEDIR_TEST_NEVER_SET"})
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		t.Fatalf("unexpected command %v %v", name, arg)
+		return nil, nil
+	})
+	if actual, err := ExpandTemplateString("{{.Vars.Profile}}", vars); err != nil || actual != "work" {
+		t.Fatalf("expected work got %v: %v", actual, err)
+	}
+}
@@ -0,0 +1,33 @@
+package homedir
+
+import "time"
+
+// DirTimed is like Dir but always performs an uncached discovery and
+// reports how long it took, for monitoring slow NSS or LDAP lookups. The
+// cache is neither consulted nor populated, so discovery is repeated on
+// every call. OverrideEnv, DefaultDir and the homedir_test build tag take
+// precedence as with Dir, and make the elapsed time negligible.
+func DirTimed() (dir string, elapsed time.Duration, err error) {
+	if homePinned() {
+		start := time.Now()
+		dir, _, err = DirSource()
+		return dir, time.Since(start), err
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	start := time.Now()
+	dir, _, err = discoverDir()
+	return dir, time.Since(start), err
+}
+
+// UserTimed is like User but always performs an uncached lookup and
+// reports how long it took, see DirTimed. The cache is neither consulted
+// nor populated.
+func UserTimed() (user string, elapsed time.Duration, err error) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	start := time.Now()
+	user, _, err = discoverUser()
+	return user, time.Since(start), err
+}
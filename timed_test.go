@@ -0,0 +1,56 @@
+package homedir
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDirTimed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passwd lookup is not supported on windows")
+	}
+
+	defer Snapshot()()
+	DisableCache = false
+	Reset()
+	SetDirEnvChain([]string{"HOMEDIR_TEST_UNSET"})
+	SetUserMethodOrder([]string{"getent"})
+	defer patchEnv("XDG_RUNTIME_DIR", "")()
+	uid := strconv.Itoa(os.Getuid())
+	stub := getentStub(map[string]string{uid: "bob:x:" + uid + ":100::/home/bob:/bin/sh"})
+	const delay = 20 * time.Millisecond
+	patchRun(func(env []string, name string, arg ...string) ([]byte, error) {
+		time.Sleep(delay)
+		if name != "getent" {
+			return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+		}
+		return stub(env, name, arg...)
+	})
+
+	dir, elapsed, err := DirTimed()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != "/home/bob" || elapsed < delay {
+		t.Fatalf("expected /home/bob after at least %v, got %v after %v", delay, dir, elapsed)
+	}
+
+	user, elapsed, err := UserTimed()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if user != "bob" || elapsed < delay {
+		t.Fatalf("expected bob after at least %v, got %v after %v", delay, user, elapsed)
+	}
+
+	cacheLock.RLock()
+	cached := homedirCache != "" || userCache != ""
+	cacheLock.RUnlock()
+	if cached {
+		t.Fatalf("timed lookups must not populate the cache")
+	}
+}
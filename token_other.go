@@ -0,0 +1,17 @@
+//go:build !windows
+
+package homedir
+
+// DirForToken returns the profile directory of the user of the current
+// process token. It is only available on Windows and returns
+// ErrUnsupportedPlatform elsewhere.
+func DirForToken() (string, error) {
+	return "", ErrUnsupportedPlatform
+}
+
+// IsSystemAccount reports whether the current process runs as the Windows
+// LocalSystem account. It is only available on Windows and returns
+// ErrUnsupportedPlatform elsewhere.
+func IsSystemAccount() (bool, error) {
+	return false, ErrUnsupportedPlatform
+}
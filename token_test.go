@@ -0,0 +1,19 @@
+package homedir
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestDirForTokenUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("supported on windows")
+	}
+
+	if _, err := DirForToken(); err != ErrUnsupportedPlatform {
+		t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+	}
+	if _, err := IsSystemAccount(); err != ErrUnsupportedPlatform {
+		t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+	}
+}
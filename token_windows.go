@@ -0,0 +1,64 @@
+//go:build windows
+
+package homedir
+
+import (
+	"syscall"
+)
+
+// tokenProfileDir returns the profile directory of the user of the current
+// process token. It is a variable so that tests can substitute it.
+var tokenProfileDir = func() (string, error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return "", err
+	}
+	defer token.Close()
+
+	return token.GetUserProfileDirectory()
+}
+
+// DirForToken returns the profile directory of the user the current process
+// runs as, obtained from its access token with GetUserProfileDirectoryW.
+// Unlike Dir it ignores the environment entirely, so it is the authoritative
+// choice for services that don't trust their environment. It is not cached.
+//
+// ErrUnsupportedPlatform is returned on other operating systems.
+func DirForToken() (string, error) {
+	return tokenProfileDir()
+}
+
+// systemSID is the well-known SID of the LocalSystem account.
+const systemSID = "S-1-5-18"
+
+// tokenUserSID returns the SID of the user of the current process token. It
+// is a variable so that tests can substitute it.
+var tokenUserSID = func() (string, error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return "", err
+	}
+	defer token.Close()
+
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return "", err
+	}
+	return user.User.Sid.String()
+}
+
+// IsSystemAccount reports whether the current process runs as LocalSystem,
+// identified by the well-known SID S-1-5-18 on its access token. Services
+// running as LocalSystem get a profile under
+// C:\Windows\system32\config\systemprofile, which is rarely a good place
+// for user data, so callers may want to use a service-specific data
+// directory instead.
+//
+// ErrUnsupportedPlatform is returned on other operating systems.
+func IsSystemAccount() (bool, error) {
+	sid, err := tokenUserSID()
+	if err != nil {
+		return false, err
+	}
+	return sid == systemSID, nil
+}
@@ -0,0 +1,61 @@
+//go:build windows
+
+package homedir
+
+import (
+	"testing"
+)
+
+func TestDirForToken(t *testing.T) {
+	if dir, err := DirForToken(); err != nil || dir == "" {
+		t.Fatalf("DirForToken() = %v, %v", dir, err)
+	}
+
+	defer func(f func() (string, error)) { tokenProfileDir = f }(tokenProfileDir)
+	tokenProfileDir = func() (string, error) {
+		return `C:\Users\svc`, nil
+	}
+	defer patchEnv("USERPROFILE", `C:\Users\other`)()
+	defer patchEnv("HOME", `C:\Users\other`)()
+
+	dir, err := DirForToken()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dir != `C:\Users\svc` {
+		t.Fatalf("expected the token profile, got %v", dir)
+	}
+}
+
+func TestIsSystemAccount(t *testing.T) {
+	if _, err := IsSystemAccount(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	defer func(f func() (string, error)) { tokenUserSID = f }(tokenUserSID)
+	for sid, expected := range map[string]bool{
+		"S-1-5-18": true,
+		"S-1-5-19": false,
+		"S-1-5-21-1004336348-1177238915-682003330-1001": false,
+	} {
+		tokenUserSID = func() (string, error) { return sid, nil }
+		actual, err := IsSystemAccount()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if actual != expected {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", sid, actual)
+		}
+	}
+}
+
+func TestSnapshotToken(t *testing.T) {
+	restore := Snapshot()
+	tokenProfileDir = nil
+	tokenUserSID = nil
+	restore()
+
+	if tokenProfileDir == nil || tokenUserSID == nil {
+		t.Fatalf("token hooks not restored")
+	}
+}
@@ -0,0 +1,48 @@
+package homedir
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandURI resolves a URI with the `home:` scheme to a filesystem path
+// beneath the home directory. The path of the URI is percent-decoded and
+// joined onto the home directory, so "home:///backups/my%20files" and
+// "home:backups/my%20files" both become "<home>/backups/my files". Any
+// other string is returned unchanged.
+//
+// A `home:` URI with a host, query or fragment is rejected, since none of
+// them has a meaning here; a literal "?" or "#" in a file name must be
+// percent-encoded.
+func ExpandURI(uri string) (string, error) {
+	if len(uri) < len("home:") || !strings.EqualFold(uri[:len("home:")], "home:") {
+		return uri, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" || u.User != nil {
+		return "", fmt.Errorf("unexpected host in %q", uri)
+	}
+	if u.RawQuery != "" || u.ForceQuery || u.Fragment != "" {
+		return "", fmt.Errorf("unexpected query or fragment in %q", uri)
+	}
+
+	path := u.Path
+	if u.Opaque != "" {
+		if path, err = url.PathUnescape(u.Opaque); err != nil {
+			return "", err
+		}
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(path)), nil
+}
@@ -0,0 +1,48 @@
+package homedir
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandURI(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+
+	cases := []struct {
+		Input  string
+		Output string
+		Err    bool
+	}{
+		{"home:///backups/x", filepath.Join(home, "backups", "x"), false},
+		{"home:/backups/x", filepath.Join(home, "backups", "x"), false},
+		{"home:backups/x", filepath.Join(home, "backups", "x"), false},
+		{"HOME:///x", filepath.Join(home, "x"), false},
+		{"home:///my%20files/a%2Fb", filepath.Join(home, "my files", "a", "b"), false},
+		{"home:my%20files", filepath.Join(home, "my files"), false},
+		{"home:///", home, false},
+		{"home:", home, false},
+		{"home://bob/x", "", true},
+		{"home:///x?y=1", "", true},
+		{"home:///x#frag", "", true},
+		{"home:///%zz", "", true},
+		{"file:///etc/x", "file:///etc/x", false},
+		{"/etc/x", "/etc/x", false},
+		{"~/x", "~/x", false},
+		{"homes:x", "homes:x", false},
+		{"", "", false},
+	}
+
+	for _, tc := range cases {
+		actual, err := ExpandURI(tc.Input)
+		if (err != nil) != tc.Err {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.Input, err)
+		}
+
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.Input, actual)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package homedir
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// DirSize returns the total size in bytes of the regular files beneath the
+// home directory. See DirSizeContext.
+func DirSize() (int64, error) {
+	return DirSizeContext(context.Background())
+}
+
+// DirSizeContext returns the total size in bytes of the regular files
+// beneath the home directory. Symlinks beneath it are not followed, so
+// nothing is counted twice and cycles are impossible; a home directory that
+// is itself a symlink, such as /home -> /usr/home on FreeBSD, is resolved
+// first.
+//
+// Errors for individual entries, such as permission denied on a
+// subdirectory, don't stop the walk: they are joined into the returned error
+// while the size of everything that could be read is still returned. If ctx
+// is cancelled the walk stops and the size counted so far is returned with
+// an error wrapping ctx.Err().
+func DirSizeContext(ctx context.Context) (int64, error) {
+	dir, err := Dir()
+	if err != nil {
+		return 0, err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return 0, err
+	}
+
+	var size int64
+	var errs []error
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		size += fi.Size()
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return size, errors.Join(errs...)
+}
@@ -0,0 +1,82 @@
+package homedir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDirSize(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	files := map[string]int{
+		"a":         10,
+		"sub/b":     20,
+		"sub/c/d":   30,
+		"locked/e":  40,
+		".hidden/f": 5,
+	}
+	for name, size := range files {
+		path := filepath.Join(home, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0600); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		// Symlinks are not followed or counted.
+		if err := os.Symlink(filepath.Join(home, "sub"), filepath.Join(home, "link")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	size, err := DirSize()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if size != 105 {
+		t.Fatalf("expected 105 got %v", size)
+	}
+
+	if runtime.GOOS != "windows" {
+		// A symlinked home directory is walked.
+		link := filepath.Join(t.TempDir(), "home")
+		if err := os.Symlink(home, link); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer patchHome(link)()
+		if size, err := DirSize(); err != nil || size != 105 {
+			t.Fatalf("expected 105 got %v, %v", size, err)
+		}
+	}
+
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		locked := filepath.Join(home, "locked")
+		if err := os.Chmod(locked, 0); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer os.Chmod(locked, 0700)
+
+		size, err = DirSize()
+		if err == nil {
+			t.Fatalf("expected a permission error")
+		}
+		if size != 65 {
+			t.Fatalf("expected 65 got %v", size)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DirSizeContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+}
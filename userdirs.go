@@ -0,0 +1,100 @@
+package homedir
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DownloadsDir returns the user's downloads directory. On Windows this is
+// the Downloads known folder, on macOS ~/Downloads, and on other systems the
+// XDG_DOWNLOAD_DIR from user-dirs.dirs, defaulting to ~/Downloads.
+func DownloadsDir() (string, error) {
+	return userDir("XDG_DOWNLOAD_DIR", "Downloads")
+}
+
+// DocumentsDir returns the user's documents directory. On Windows this is
+// the Documents known folder, on macOS ~/Documents, and on other systems the
+// XDG_DOCUMENTS_DIR from user-dirs.dirs, defaulting to ~/Documents.
+func DocumentsDir() (string, error) {
+	return userDir("XDG_DOCUMENTS_DIR", "Documents")
+}
+
+// DesktopDir returns the user's desktop directory. On Windows this is the
+// Desktop known folder, on macOS ~/Desktop, and on other systems the
+// XDG_DESKTOP_DIR from user-dirs.dirs, defaulting to ~/Desktop.
+func DesktopDir() (string, error) {
+	return userDir("XDG_DESKTOP_DIR", "Desktop")
+}
+
+// userDir returns the location of the user directory known as key in
+// user-dirs.dirs and as name on Windows and macOS.
+func userDir(key, name string) (string, error) {
+	if runtime.GOOS == "windows" {
+		return KnownFolder(name)
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	if runtime.GOOS != "darwin" {
+		config, err := ConfigDir()
+		if err != nil {
+			return "", err
+		}
+		if f, err := os.Open(filepath.Join(config, "user-dirs.dirs")); err == nil {
+			dirs := parseUserDirs(f, dir)
+			f.Close()
+			if d := dirs[key]; d != "" {
+				return d, nil
+			}
+		}
+	}
+
+	return filepath.Join(dir, name), nil
+}
+
+// parseUserDirs parses a user-dirs.dirs file as written by xdg-user-dirs.
+// Each line has the form
+//
+//	XDG_DOWNLOAD_DIR="$HOME/Downloads"
+//
+// where the value is either an absolute path or relative to $HOME. Other
+// lines are ignored.
+func parseUserDirs(r io.Reader, home string) map[string]string {
+	dirs := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], line[i+1:]
+		if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+			continue
+		}
+		value = value[1 : len(value)-1]
+
+		switch {
+		case value == "$HOME":
+			value = home
+		case strings.HasPrefix(value, "$HOME/"):
+			value = filepath.Join(home, value[len("$HOME/"):])
+		case !strings.HasPrefix(value, "/"):
+			continue
+		}
+		dirs[key] = filepath.Clean(value)
+	}
+
+	return dirs
+}
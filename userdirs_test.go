@@ -0,0 +1,80 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const sampleUserDirs = `# This file is written by xdg-user-dirs-update
+# If you want to change or add directories, just edit the line you're
+# interested in. All local changes will be retained on the next run.
+XDG_DESKTOP_DIR="$HOME/Desktop"
+XDG_DOWNLOAD_DIR="$HOME/Incoming/files"
+XDG_DOCUMENTS_DIR="/srv/docs/bob"
+XDG_MUSIC_DIR="$HOME"
+XDG_PICTURES_DIR=relative/ignored
+XDG_VIDEOS_DIR="relative/ignored"
+`
+
+func TestParseUserDirs(t *testing.T) {
+	actual := parseUserDirs(strings.NewReader(sampleUserDirs), "/home/bob")
+	expected := map[string]string{
+		"XDG_DESKTOP_DIR":   filepath.Clean("/home/bob/Desktop"),
+		"XDG_DOWNLOAD_DIR":  filepath.Clean("/home/bob/Incoming/files"),
+		"XDG_DOCUMENTS_DIR": filepath.Clean("/srv/docs/bob"),
+		"XDG_MUSIC_DIR":     filepath.Clean("/home/bob"),
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v got %v", expected, actual)
+	}
+}
+
+func TestUserDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("known folders are used on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchEnv("HOME", home)()
+	defer patchEnv("XDG_CONFIG_HOME", "")()
+
+	if err := os.MkdirAll(filepath.Join(home, ".config"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err := os.WriteFile(filepath.Join(home, ".config", "user-dirs.dirs"), []byte(sampleUserDirs), 0600)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	downloads := filepath.Join(home, "Incoming", "files")
+	documents := "/srv/docs/bob"
+	if runtime.GOOS == "darwin" {
+		downloads = filepath.Join(home, "Downloads")
+		documents = filepath.Join(home, "Documents")
+	}
+
+	cases := []struct {
+		F      func() (string, error)
+		Output string
+	}{
+		{DownloadsDir, downloads},
+		{DocumentsDir, documents},
+		{DesktopDir, filepath.Join(home, "Desktop")},
+	}
+
+	for _, tc := range cases {
+		actual, err := tc.F()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("expected %v got %v", tc.Output, actual)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package homedir
+
+// UserQualified returns the domain-qualified name of the executing user in
+// the DOMAIN\user form. It is only available on Windows and returns
+// ErrUnsupportedPlatform elsewhere.
+func UserQualified() (string, error) {
+	return "", ErrUnsupportedPlatform
+}
+
+// UserPrincipalName returns the user principal name of the executing user.
+// It is only available on Windows and returns ErrUnsupportedPlatform
+// elsewhere.
+func UserPrincipalName() (string, error) {
+	return "", ErrUnsupportedPlatform
+}
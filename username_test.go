@@ -0,0 +1,37 @@
+package homedir
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUserQualified(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		if _, err := UserQualified(); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+		}
+		if _, err := UserPrincipalName(); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform got %v", err)
+		}
+		return
+	}
+
+	Reset()
+	defer Reset()
+	name, err := UserQualified()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(name, `\`) {
+		t.Fatalf("expected DOMAIN\\user, got %q", name)
+	}
+	if cached, _ := UserQualified(); cached != name {
+		t.Fatalf("cached %q != %q", cached, name)
+	}
+
+	// Local accounts have no UPN, so only check that a result is plausible.
+	if upn, err := UserPrincipalName(); err == nil && !strings.Contains(upn, "@") {
+		t.Fatalf("unexpected UPN %q", upn)
+	}
+}
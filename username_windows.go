@@ -0,0 +1,77 @@
+//go:build windows
+
+package homedir
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modsecur32 = syscall.NewLazyDLL("secur32.dll")
+
+	procGetUserNameExW = modsecur32.NewProc("GetUserNameExW")
+)
+
+// EXTENDED_NAME_FORMAT values accepted by GetUserNameExW.
+const (
+	nameSamCompatible = 2
+	nameUserPrincipal = 8
+)
+
+// UserQualified returns the domain-qualified name of the executing user in
+// the DOMAIN\user form, as reported by GetUserNameExW with
+// NameSamCompatible. Unlike the bare name returned by User() it is
+// unambiguous for ACL and directory lookups. It is cached separately from
+// User().
+//
+// ErrUnsupportedPlatform is returned on other operating systems.
+func UserQualified() (string, error) {
+	return userNameEx("NameSamCompatible", nameSamCompatible)
+}
+
+// UserPrincipalName is like UserQualified but returns the user principal
+// name, such as bob@example.com, using NameUserPrincipal. This fails for
+// local accounts, which have no UPN.
+//
+// ErrUnsupportedPlatform is returned on other operating systems.
+func UserPrincipalName() (string, error) {
+	return userNameEx("NameUserPrincipal", nameUserPrincipal)
+}
+
+// userNameEx calls GetUserNameExW with format, caching the result under
+// name.
+func userNameEx(name string, format uint32) (string, error) {
+	if !DisableCache {
+		cacheLock.RLock()
+		cached := qualifiedUserCache[name]
+		cacheLock.RUnlock()
+		if cached != "" {
+			return cached, nil
+		}
+	}
+
+	n := uint32(256)
+	for {
+		buf := make([]uint16, n)
+		size := n
+		r, _, e := procGetUserNameExW.Call(uintptr(format),
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+		if r != 0 {
+			result := syscall.UTF16ToString(buf[:size])
+			cacheLock.Lock()
+			qualifiedUserCache[name] = result
+			cacheLock.Unlock()
+			return result, nil
+		}
+		if e != syscall.ERROR_MORE_DATA {
+			return "", fmt.Errorf("GetUserNameEx(%s) failed: %v", name, e)
+		}
+		// On ERROR_MORE_DATA size holds the required length.
+		if size <= n {
+			size = 2 * n
+		}
+		n = size
+	}
+}
@@ -0,0 +1,90 @@
+package homedir
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// UserInfo describes an account in the passwd database.
+type UserInfo struct {
+	Name string
+	UID  int
+	Dir  string
+}
+
+// AllUsers returns every account listed by `getent passwd`, in the order
+// getent reports them. See AllUsersContext.
+func AllUsers() ([]UserInfo, error) {
+	return AllUsersContext(context.Background())
+}
+
+// AllUsersContext is like AllUsers but stops the enumeration when ctx is
+// done, which matters on systems backed by a slow directory service such as
+// LDAP. The output of getent is parsed as it arrives, and on cancellation
+// getent is killed and an error wrapping ctx.Err() is returned.
+//
+// Malformed entries are skipped. ErrUnsupportedPlatform is returned on
+// Windows.
+func AllUsersContext(ctx context.Context) ([]UserInfo, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrUnsupportedPlatform
+	}
+
+	// Cancelling kills getent, which is also how it is stopped when the
+	// output cannot be parsed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdout, wait, err := runStream(ctx, "getent", "passwd")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("user enumeration cancelled: %w", ctx.Err())
+		}
+		return nil, err
+	}
+
+	users, err := parseUsers(ctx, stdout)
+	if err != nil {
+		cancel()
+		wait()
+		return nil, err
+	}
+	if err := wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("user enumeration cancelled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("getent passwd: %v", err)
+	}
+
+	return users, nil
+}
+
+// parseUsers reads passwd entries from r until EOF or until ctx is done.
+func parseUsers(ctx context.Context, r io.Reader) ([]UserInfo, error) {
+	var users []UserInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("user enumeration cancelled: %w", ctx.Err())
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		entry, err := ParsePasswdLine(line)
+		if err != nil {
+			continue
+		}
+		users = append(users, UserInfo{Name: entry.Name, UID: entry.UID, Dir: entry.Dir})
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("user enumeration cancelled: %w", ctx.Err())
+	}
+
+	return users, scanner.Err()
+}
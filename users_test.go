@@ -0,0 +1,110 @@
+package homedir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseUsers(t *testing.T) {
+	input := "root:x:0:0:root:/root:/bin/sh\n" +
+		"# comment\n" +
+		"broken line\n" +
+		"\n" +
+		"bob:x:1000:1000:Bob:/home/bob:/bin/sh\n"
+
+	users, err := parseUsers(context.Background(), strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []UserInfo{{"root", 0, "/root"}, {"bob", 1000, "/home/bob"}}
+	if !reflect.DeepEqual(users, expected) {
+		t.Fatalf("expected %v got %v", expected, users)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parseUsers(ctx, strings.NewReader(input)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAllUsersContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		if _, err := AllUsers(); err != ErrUnsupportedPlatform {
+			t.Fatalf("expected ErrUnsupportedPlatform, got %v", err)
+		}
+		return
+	}
+
+	defer Snapshot()()
+	var midway func()
+	var waited bool
+	runStream = func(ctx context.Context, name string, arg ...string) (io.Reader, func() error, error) {
+		if name != "getent" || len(arg) != 1 || arg[0] != "passwd" {
+			return nil, nil, fmt.Errorf("unexpected command %v %v", name, arg)
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		// Like a slow directory service, the output arrives line by line
+		r, w := io.Pipe()
+		exited := make(chan error, 1)
+		go func() {
+			w.Write([]byte("root:x:0:0:root:/root:/bin/sh\n"))
+			if midway != nil {
+				midway()
+				<-ctx.Done()
+				w.CloseWithError(ctx.Err())
+				exited <- ctx.Err()
+				return
+			}
+			w.Write([]byte("bob:x:1000:1000:Bob:/home/bob:/bin/sh\n"))
+			w.Close()
+			exited <- nil
+		}()
+		return r, func() error {
+			waited = true
+			return <-exited
+		}, nil
+	}
+
+	users, err := AllUsersContext(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []UserInfo{{"root", 0, "/root"}, {"bob", 1000, "/home/bob"}}
+	if !reflect.DeepEqual(users, expected) {
+		t.Fatalf("expected %v got %v", expected, users)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AllUsersContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Cancelling while getent is still producing output stops it
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	midway, waited = cancel, false
+	if _, err := AllUsersContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !waited {
+		t.Fatalf("getent was not waited for after cancellation")
+	}
+
+	runStream = func(ctx context.Context, name string, arg ...string) (io.Reader, func() error, error) {
+		return nil, nil, errors.New("getent not found")
+	}
+	if _, err := AllUsersContext(context.Background()); err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected getent error, got %v", err)
+	}
+}
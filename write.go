@@ -0,0 +1,99 @@
+package homedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tempSeq makes the names of the temporary files of WriteFile unique within
+// the process.
+var tempSeq uint64
+
+// WriteFile writes data to the file relpath beneath the home directory,
+// creating missing parent directories with permissions 0700. The data is
+// written to a temporary file in the same directory, which is then renamed
+// over relpath, so readers see either the old or the new contents but never
+// a partial write. The file gets permissions perm (before umask) and a
+// concurrent writer's file is replaced as a whole.
+//
+// relpath is joined onto the home directory like Join, but a result outside
+// the home directory is rejected even if AllowJoinEscape is set. A leading
+// `~` is accepted and stands for the home directory as well, so "~/x" and
+// "x" name the same file; `~user` is an error. The errors tell resolving the
+// path, creating the parent directory and writing the file apart.
+func WriteFile(relpath string, data []byte, perm os.FileMode) error {
+	name := relpath
+	if ok, err := hasTilde(name); err != nil {
+		return err
+	} else if ok {
+		name = strings.TrimLeftFunc(name[1:], func(r rune) bool { return r < 0x80 && os.IsPathSeparator(uint8(r)) })
+	}
+
+	path, err := Join(name)
+	if err != nil {
+		return err
+	}
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q does not name a file in the home directory", relpath)
+	}
+
+	parent := filepath.Dir(path)
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return fmt.Errorf("cannot create parent directory: %w", err)
+	}
+
+	if err := writeAtomic(path, data, perm); err != nil {
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// to path. The temporary file is created with perm, so the umask applies as
+// it would with os.WriteFile, and is removed on failure.
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := createTemp(path, perm)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// createTemp creates a new file next to path with permissions perm (before
+// umask). Unlike os.CreateTemp, which always uses 0600, it lets the umask
+// decide the final permissions.
+func createTemp(path string, perm os.FileMode) (*os.File, error) {
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	for i := 0; ; i++ {
+		name := prefix + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(atomic.AddUint64(&tempSeq, 1), 36)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
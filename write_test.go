@@ -0,0 +1,142 @@
+package homedir
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	if err := WriteFile(filepath.Join("a", "b", "config.toml"), []byte("x = 1\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	path := filepath.Join(home, "a", "b", "config.toml")
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "x = 1\n" {
+		t.Fatalf("unexpected contents %q: %v", data, err)
+	}
+	if fi, err := os.Stat(path); err != nil || runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected mode: %v %v", fi.Mode(), err)
+	}
+
+	// Overwriting replaces the contents as a whole
+	if err := WriteFile(filepath.Join("a", "b", "config.toml"), []byte("y = 2\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "y = 2\n" {
+		t.Fatalf("unexpected contents %q", data)
+	}
+
+	// The umask applies like with os.WriteFile
+	ref := filepath.Join(t.TempDir(), "ref")
+	if err := os.WriteFile(ref, nil, 0666); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := WriteFile("shared", nil, 0666); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	refInfo, _ := os.Stat(ref)
+	if fi, err := os.Stat(filepath.Join(home, "shared")); err != nil || fi.Mode().Perm() != refInfo.Mode().Perm() {
+		t.Fatalf("expected mode %v got %v: %v", refInfo.Mode().Perm(), fi.Mode().Perm(), err)
+	}
+
+	// A leading tilde stands for the home directory
+	for _, relpath := range []string{"~/tilde.txt", "~" + string(filepath.Separator) + "tilde.txt"} {
+		if err := WriteFile(relpath, []byte("t"), 0600); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if data, err := os.ReadFile(filepath.Join(home, "tilde.txt")); err != nil || string(data) != "t" {
+			t.Fatalf("expected %q to be written to the home directory: %q %v", relpath, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(home, "~")); !os.IsNotExist(err) {
+		t.Fatalf("a literal ~ directory was created: %v", err)
+	}
+	if err := WriteFile("~bob/x", nil, 0600); err == nil {
+		t.Fatalf("expected ~bob/x to be rejected")
+	}
+
+	AllowJoinEscape = true
+	for _, relpath := range []string{"../outside", filepath.Join("a", "..", "..", "outside"), "", ".", nativePath("/abs")} {
+		if err := WriteFile(relpath, nil, 0600); err == nil {
+			t.Fatalf("expected %q to be rejected", relpath)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(home), "outside")); !os.IsNotExist(err) {
+		t.Fatalf("file written outside home: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, "file"), nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := WriteFile(filepath.Join("file", "sub"), nil, 0600); err == nil || !strings.Contains(err.Error(), "cannot create parent directory") {
+		t.Fatalf("expected mkdir error got %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(home, "dir"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := WriteFile("dir", nil, 0600); err == nil || !strings.Contains(err.Error(), "cannot write") {
+		t.Fatalf("expected write error got %v", err)
+	}
+}
+
+func TestWriteFileConcurrent(t *testing.T) {
+	defer Snapshot()()
+	DisableCache = true
+	home := t.TempDir()
+	defer patchHome(home)()
+
+	const writers = 8
+	payloads := make([][]byte, writers)
+	for i := range payloads {
+		payloads[i] = bytes.Repeat([]byte(fmt.Sprint(i)), 64*1024)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for _, payload := range payloads {
+		wg.Add(1)
+		go func(payload []byte) {
+			defer wg.Done()
+			errs <- WriteFile("state", payload, 0600)
+		}(payload)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		// Renaming over a file that is being replaced can fail on Windows
+		if err != nil && runtime.GOOS != "windows" {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, "state"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	complete := false
+	for _, payload := range payloads {
+		complete = complete || bytes.Equal(data, payload)
+	}
+	if !complete {
+		t.Fatalf("file holds a partial or mixed write of %d bytes", len(data))
+	}
+
+	entries, err := os.ReadDir(home)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
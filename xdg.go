@@ -0,0 +1,242 @@
+package homedir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ConfigDir returns the directory for user-specific configuration files.
+//
+// On Unix systems it is $XDG_CONFIG_HOME if that is set to an absolute
+// path, and ~/.config otherwise. On macOS it is
+// ~/Library/Application Support and on Windows it is %AppData%.
+func ConfigDir() (string, error) {
+	return baseDir("AppData", []string{"AppData", "Roaming"}, []string{"Library", "Application Support"}, "XDG_CONFIG_HOME", ".config")
+}
+
+// CacheDir returns the directory for user-specific cached data.
+//
+// On Unix systems it is $XDG_CACHE_HOME if that is set to an absolute path,
+// and ~/.cache otherwise. On macOS it is ~/Library/Caches and on Windows it
+// is %LocalAppData%.
+func CacheDir() (string, error) {
+	return baseDir("LocalAppData", []string{"AppData", "Local"}, []string{"Library", "Caches"}, "XDG_CACHE_HOME", ".cache")
+}
+
+// DataDir returns the directory for user-specific data files.
+//
+// On Unix systems it is $XDG_DATA_HOME if that is set to an absolute path,
+// and ~/.local/share otherwise. On macOS it is
+// ~/Library/Application Support and on Windows it is %LocalAppData%.
+func DataDir() (string, error) {
+	return baseDir("LocalAppData", []string{"AppData", "Local"}, []string{"Library", "Application Support"}, "XDG_DATA_HOME", ".local", "share")
+}
+
+// baseDir returns the Windows directory in winEnv, the macOS directory
+// darwin under the home directory, or the XDG directory in xdgEnv falling
+// back to unix under the home directory. If the home directory is pinned, see
+// OverrideEnv, the variables are ignored and the Windows directory is win
+// under the home directory.
+func baseDir(winEnv string, win, darwin []string, xdgEnv string, unix ...string) (string, error) {
+	pinned := homePinned()
+	switch runtime.GOOS {
+	case "windows":
+		if pinned {
+			return homeJoin(win...)
+		}
+		if dir := os.Getenv(winEnv); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("%s is blank", winEnv)
+	case "darwin":
+		return homeJoin(darwin...)
+	}
+
+	if dir := os.Getenv(xdgEnv); filepath.IsAbs(dir) && !pinned {
+		return dir, nil
+	}
+	return homeJoin(unix...)
+}
+
+// RuntimeDir returns the directory for user-specific runtime files such as
+// sockets and pidfiles, $XDG_RUNTIME_DIR. An error is returned if it is not
+// set to an absolute path, which is always the case on Windows and macOS.
+func RuntimeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	return "", errors.New("XDG_RUNTIME_DIR is blank or not absolute")
+}
+
+// RunDir returns the directory for the pidfiles and locks of app, creating
+// it with permissions 0700 if it doesn't exist. It is RuntimeDir()/app if
+// there is a runtime directory, and otherwise <user>-<app> in os.TempDir(),
+// typically /tmp, on Unix systems and app in %TEMP% on Windows. Since the
+// temporary directory may be shared with other users, an existing directory
+// there must be owned by the current user and not be accessible to anyone
+// else.
+func RunDir(app string) (string, error) {
+	if base, err := RuntimeDir(); err == nil {
+		dir := filepath.Join(base, app)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("cannot create run directory: %v", err)
+		}
+		return dir, nil
+	}
+
+	if runtime.GOOS == "windows" {
+		dir := filepath.Join(os.TempDir(), app)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("cannot create run directory: %v", err)
+		}
+		return dir, nil
+	}
+
+	user, err := User()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(os.TempDir(), user+"-"+app)
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("cannot create run directory: %v", err)
+	}
+
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() || fi.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("run directory %s is not a private directory", dir)
+	}
+	if uid, err := fileOwner(fi); err == nil && uid != os.Getuid() {
+		return "", fmt.Errorf("run directory %s is owned by uid %d", dir, uid)
+	}
+	return dir, nil
+}
+
+// AppDirs returns the configuration, cache and data directories of app,
+// that is ConfigDir()/app, CacheDir()/app and DataDir()/app, creating each
+// with permissions 0700 if it doesn't exist. On macOS the configuration and
+// data directories are the same, as are the cache and data directories on
+// Windows.
+//
+// Errors resolving the base directories are returned as-is with no paths.
+// Failures to create the app directories are joined into err, and all three
+// paths are still returned.
+func AppDirs(app string) (config, cache, data string, err error) {
+	bases := []func() (string, error){ConfigDir, CacheDir, DataDir}
+	dirs := make([]string, len(bases))
+	for i, base := range bases {
+		dir, err := base()
+		if err != nil {
+			return "", "", "", err
+		}
+		dirs[i] = filepath.Join(dir, app)
+	}
+
+	var errs []error
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			errs = append(errs, fmt.Errorf("cannot create app directory: %v", err))
+		}
+	}
+
+	return dirs[0], dirs[1], dirs[2], errors.Join(errs...)
+}
+
+// ConfigFile returns the path of the configuration file name for app,
+// ConfigDir()/app/name. The app directory is created with permissions 0700
+// if it doesn't exist, but the file itself is not created.
+//
+// Errors resolving the configuration directory are returned as-is, while a
+// failure to create the app directory is reported as such.
+func ConfigFile(app, name string) (string, error) {
+	config, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(config, app)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create config directory: %v", err)
+	}
+
+	return filepath.Join(dir, name), nil
+}
+
+// InstanceDir returns the directory of instance id of app,
+// ConfigDir()/app/instances/id, for applications that run several instances
+// for the same user. It is created with permissions 0700 if it doesn't
+// exist. id must be a single path element: it may not be empty, "." or
+// "..", or contain a path separator, so that it cannot escape the instances
+// directory.
+func InstanceDir(app, id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid instance id %q", id)
+	}
+
+	config, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(config, app, "instances", id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create instance directory: %v", err)
+	}
+
+	return dir, nil
+}
+
+// LegacyConfigPaths returns the locations where the configuration of app
+// may be found when migrating it from older locations: first the current
+// one, ConfigDir()/app, followed by each of legacy in order. Legacy paths may
+// be relative to the home directory, as in ".myapp" or ".myapp/config.toml",
+// start with `~` or be absolute. Duplicates are removed, and locations that
+// cannot be determined, for example because the home directory is unknown,
+// are left out. The files are not checked for existence.
+func LegacyConfigPaths(app string, legacy ...string) []string {
+	var paths []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	if config, err := ConfigDir(); err == nil {
+		add(filepath.Join(config, app))
+	}
+
+	dir, dirErr := Dir()
+	for _, path := range legacy {
+		switch {
+		case filepath.IsAbs(path):
+			add(filepath.Clean(path))
+		case dirErr != nil:
+		case strings.HasPrefix(path, "~"):
+			if expanded, err := ExpandFrom(dir, path); err == nil {
+				add(expanded)
+			}
+		default:
+			add(filepath.Join(dir, path))
+		}
+	}
+
+	return paths
+}
+
+// homeJoin joins elem onto the home directory.
+func homeJoin(elem ...string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
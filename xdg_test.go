@@ -0,0 +1,281 @@
+package homedir
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestConfigDir(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	defer patchHome(home)()
+	defer patchEnv("XDG_CONFIG_HOME", "")()
+	defer patchEnv("AppData", filepath.Join(home, "AppData", "Roaming"))()
+
+	cases := []struct {
+		XDG    string
+		Output string
+	}{
+		{"", filepath.Join(home, ".config")},
+		{nativePath("/srv/config"), nativePath("/srv/config")},
+		{"relative/config", filepath.Join(home, ".config")},
+	}
+
+	for _, tc := range cases {
+		os.Setenv("XDG_CONFIG_HOME", tc.XDG)
+		switch runtime.GOOS {
+		case "windows":
+			tc.Output = filepath.Join(home, "AppData", "Roaming")
+		case "darwin":
+			tc.Output = filepath.Join(home, "Library", "Application Support")
+		}
+
+		actual, err := ConfigDir()
+		if err != nil {
+			t.Fatalf("Input: %#v\n\nErr: %s", tc.XDG, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v\n\nOutput: %#v", tc.XDG, actual)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	config := filepath.Join(home, "config")
+	defer patchHome(home)()
+	defer patchEnv("XDG_CONFIG_HOME", config)()
+	defer patchEnv("AppData", config)()
+	if runtime.GOOS == "darwin" {
+		config = filepath.Join(home, "Library", "Application Support")
+	}
+
+	path, err := ConfigFile("myapp", "config.toml")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(config, "myapp", "config.toml"); path != expected {
+		t.Fatalf("expected %v got %v", expected, path)
+	}
+
+	fi, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected mode 0700 got %v", fi.Mode().Perm())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("config file should not be created: %v", err)
+	}
+}
+
+func TestCacheAndDataDir(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	local := filepath.Join(home, "AppData", "Local")
+	defer patchHome(home)()
+	defer patchEnv("XDG_CACHE_HOME", "")()
+	defer patchEnv("XDG_DATA_HOME", "")()
+	defer patchEnv("LocalAppData", local)()
+
+	cases := []struct {
+		Func   func() (string, error)
+		Env    string
+		XDG    string
+		Output string
+	}{
+		{CacheDir, "XDG_CACHE_HOME", "", filepath.Join(home, ".cache")},
+		{CacheDir, "XDG_CACHE_HOME", nativePath("/srv/cache"), nativePath("/srv/cache")},
+		{CacheDir, "XDG_CACHE_HOME", "relative", filepath.Join(home, ".cache")},
+		{DataDir, "XDG_DATA_HOME", "", filepath.Join(home, ".local", "share")},
+		{DataDir, "XDG_DATA_HOME", nativePath("/srv/data"), nativePath("/srv/data")},
+	}
+
+	for _, tc := range cases {
+		os.Setenv(tc.Env, tc.XDG)
+		switch runtime.GOOS {
+		case "windows":
+			tc.Output = local
+		case "darwin":
+			if tc.Env == "XDG_CACHE_HOME" {
+				tc.Output = filepath.Join(home, "Library", "Caches")
+			} else {
+				tc.Output = filepath.Join(home, "Library", "Application Support")
+			}
+		}
+
+		actual, err := tc.Func()
+		os.Setenv(tc.Env, "")
+		if err != nil {
+			t.Fatalf("Input: %#v=%#v\n\nErr: %s", tc.Env, tc.XDG, err)
+		}
+		if actual != tc.Output {
+			t.Fatalf("Input: %#v=%#v\n\nOutput: %#v", tc.Env, tc.XDG, actual)
+		}
+	}
+}
+
+func TestAppDirs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG variables are not used on " + runtime.GOOS)
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	defer patchHome(home)()
+	defer patchEnv("XDG_CONFIG_HOME", filepath.Join(home, "config"))()
+	defer patchEnv("XDG_CACHE_HOME", "")()
+	defer patchEnv("XDG_DATA_HOME", filepath.Join(home, "data"))()
+
+	config, cache, data, err := AppDirs("myapp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{
+		filepath.Join(home, "config", "myapp"),
+		filepath.Join(home, ".cache", "myapp"),
+		filepath.Join(home, "data", "myapp"),
+	}
+	for i, dir := range []string{config, cache, data} {
+		if dir != expected[i] {
+			t.Fatalf("expected %v got %v", expected[i], dir)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+			t.Fatalf("expected directory with mode 0700, got %v", fi.Mode())
+		}
+	}
+
+	// A cache base that is a file cannot hold the app directory
+	file := filepath.Join(home, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Setenv("XDG_CACHE_HOME", file)
+	config, cache, data, err = AppDirs("myapp")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if config != expected[0] || cache != filepath.Join(file, "myapp") || data != expected[2] {
+		t.Fatalf("unexpected paths %v %v %v", config, cache, data)
+	}
+}
+
+func TestInstanceDir(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := t.TempDir()
+	config := filepath.Join(home, "config")
+	defer patchHome(home)()
+	defer patchEnv("XDG_CONFIG_HOME", config)()
+	defer patchEnv("AppData", config)()
+	if runtime.GOOS == "darwin" {
+		config = filepath.Join(home, "Library", "Application Support")
+	}
+
+	dir, err := InstanceDir("myapp", "worker-1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(config, "myapp", "instances", "worker-1"); dir != expected {
+		t.Fatalf("expected %v got %v", expected, dir)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected mode 0700 got %v", fi.Mode().Perm())
+	}
+
+	for _, id := range []string{"", ".", "..", "a/b", "../x", `a\b`} {
+		if dir, err := InstanceDir("myapp", id); err == nil {
+			t.Fatalf("Input: %#v\n\nexpected error, got %v", id, dir)
+		}
+	}
+}
+
+func TestLegacyConfigPaths(t *testing.T) {
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	home := nativePath("/home/bob")
+	config := nativePath("/srv/config")
+	defer patchHome(home)()
+	defer patchEnv("XDG_CONFIG_HOME", config)()
+	defer patchEnv("AppData", config)()
+	switch runtime.GOOS {
+	case "darwin":
+		config = filepath.Join(home, "Library", "Application Support")
+	}
+
+	actual := LegacyConfigPaths("myapp", ".myapp", "~/.myapprc", nativePath("/etc/myapp/"), ".myapp", "~bob/x", filepath.Join(config, "myapp"))
+	expected := []string{
+		filepath.Join(config, "myapp"),
+		filepath.Join(home, ".myapp"),
+		filepath.Join(home, ".myapprc"),
+		nativePath("/etc/myapp"),
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %#v got %#v", expected, actual)
+	}
+}
+
+func TestRunDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the temporary directory is per user on windows")
+	}
+
+	DisableCache = true
+	defer func() { DisableCache = false }()
+	defer patchEnv("USER", "bob")()
+
+	xdg := t.TempDir()
+	defer patchEnv("XDG_RUNTIME_DIR", xdg)()
+	dir, err := RunDir("myapp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(xdg, "myapp"); dir != expected {
+		t.Fatalf("expected %v got %v", expected, dir)
+	}
+
+	tmp := t.TempDir()
+	defer patchEnv("TMPDIR", tmp)()
+	os.Setenv("XDG_RUNTIME_DIR", "")
+	for i := 0; i < 2; i++ {
+		dir, err = RunDir("myapp")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if expected := filepath.Join(tmp, "bob-myapp"); dir != expected {
+			t.Fatalf("expected %v got %v", expected, dir)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if fi.Mode().Perm() != 0700 {
+			t.Fatalf("expected mode 0700 got %v", fi.Mode().Perm())
+		}
+	}
+
+	// A directory others can write to may have been planted
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := RunDir("myapp"); err == nil {
+		t.Fatalf("expected error for a shared run directory")
+	}
+}